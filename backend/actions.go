@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Actions a client can send, the dispatcher decodes the payload of the envelope into a new value of the registered type
+var actions = map[string]func() Action{
+	"connect":             func() Action { return &ConnectAction{} },
+	"cancel_queue":        func() Action { return &CancelQueueAction{} },
+	"create_private_room": func() Action { return &CreatePrivateRoomAction{} },
+	"join_private_room":   func() Action { return &JoinPrivateRoomAction{} },
+	"start_private_room":  func() Action { return &StartPrivateRoomAction{} },
+	"submit_answer":       func() Action { return &SubmitAnswerAction{} },
+	"rematch_request":     func() Action { return &RematchRequestAction{} },
+	"rematch_accept":      func() Action { return &RematchAcceptAction{} },
+	"rematch_decline":     func() Action { return &RematchDeclineAction{} },
+	"team_chat":           func() Action { return &TeamChatAction{} },
+	"resume":              func() Action { return &ResumeAction{} },
+	"spectate":            func() Action { return &SpectateAction{} },
+	"stop_spectating":     func() Action { return &StopSpectatingAction{} },
+	"replay":              func() Action { return &ReplayAction{} },
+	"stop_replay":         func() Action { return &StopReplayAction{} },
+	"disconnect":          func() Action { return &DisconnectAction{} },
+}
+
+// The user searches for opponents, paired with the player waiting the longest within its rating window or waits in the queue
+type ConnectAction struct {
+	// "ffa" for a free-for-all match, "team" for a 2v2 team match, a duel otherwise
+	Mode string `json:"mode,omitempty"`
+}
+
+func (a *ConnectAction) handle(client *Client) error {
+	// A finished room kept open for a rematch is left when the user searches for a new opponent
+	if !leaveFinishedRoom(client.ProfileName) {
+		return errAlreadyInRoom
+	}
+	player := client.player()
+	// Players who keep abandoning ranked matches wait before they can queue again, they get queue_cooldown instead
+	if !checkQueueCooldown(player) {
+		return nil
+	}
+	// A player searches in one mode at a time
+	leaveOtherQueues(client.ProfileName, client.Connection, a.Mode)
+	// Free-for-all and team players wait in their lobby until enough players joined
+	if lobby, startGroup := groupLobby(a.Mode); lobby != nil {
+		group, position, err := lobby.Join(player)
+		if err != nil {
+			return err
+		}
+		if group == nil {
+			sendQueuePosition(player, position)
+			return nil
+		}
+		startGroup(group)
+		return nil
+	}
+	// Rating decides who the user can be matched with
+	rating, err := getRating(context.TODO(), client.ProfileName)
+	if err != nil {
+		return err
+	}
+	opponent, position, err := matchmaking.Enqueue(player, rating)
+	if err != nil {
+		return err
+	}
+	// Nobody is waiting so tell the user where they are in the queue
+	if opponent == nil {
+		sendQueuePosition(player, position)
+		return nil
+	}
+	//* We found an equally skilled opponent (within the rating window)
+	createMatch(*opponent, player)
+	return nil
+}
+
+// The user stops searching for an opponent
+type CancelQueueAction struct{}
+
+func (a *CancelQueueAction) handle(client *Client) error {
+	if leaveQueue(client.ProfileName) {
+		return client.Connection.Send("queue_cancelled", nil)
+	}
+	return nil
+}
+
+// The user creates a room friends can join with the invite code instead of searching for random opponents
+type CreatePrivateRoomAction struct {
+	// The host opts in to trophy and rating changes
+	Ranked bool   `json:"ranked,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	// Size of free-for-all rooms (3 to 8 players)
+	MaxPlayers int `json:"maxPlayers,omitempty"`
+}
+
+func (a *CreatePrivateRoomAction) handle(client *Client) error {
+	// Ranked private rooms change trophies and ratings so the abandonment cooldown applies like in the queue
+	if a.Ranked && !checkQueueCooldown(client.player()) {
+		return nil
+	}
+	// A profile in a room can't be matched so it leaves every queue, also the ones it joined from another tab
+	leaveQueue(client.ProfileName)
+	leaveFinishedRoom(client.ProfileName)
+	room, err := rooms.CreatePrivate(a.Ranked, a.Mode, a.MaxPlayers, client.player())
+	if err != nil {
+		return err
+	}
+	return client.Connection.Send("private_room_created", PrivateRoomCreatedMessage{
+		RoomId:     room.ID,
+		InviteCode: room.InviteCode,
+		Ranked:     room.Ranked,
+		MaxPlayers: room.MaxPlayers,
+	})
+}
+
+// A friend joins with the invite code and the match starts once the room is full
+type JoinPrivateRoomAction struct {
+	InviteCode string `json:"inviteCode"`
+}
+
+func (a *JoinPrivateRoomAction) handle(client *Client) error {
+	if rooms.IsRankedPrivate(a.InviteCode) && !checkQueueCooldown(client.player()) {
+		return nil
+	}
+	// A profile in a room can't be matched so it leaves every queue, also the ones it joined from another tab
+	leaveQueue(client.ProfileName)
+	leaveFinishedRoom(client.ProfileName)
+	room, full, err := rooms.JoinPrivate(a.InviteCode, client.player())
+	if err != nil {
+		return err
+	}
+	if full {
+		startMatch(room)
+		return nil
+	}
+	announcePrivateRoomPlayers(room)
+	return nil
+}
+
+// Any player in a private room which is not full yet starts the match with the players who joined so far
+type StartPrivateRoomAction struct{}
+
+func (a *StartPrivateRoomAction) handle(client *Client) error {
+	room, err := rooms.StartPrivate(client.ProfileName)
+	if err != nil {
+		return err
+	}
+	startMatch(room)
+	return nil
+}
+
+// The player answers the current question and the server checks it against the question bank
+type SubmitAnswerAction struct {
+	RoomId     string `json:"roomId"`
+	QuestionId string `json:"questionId"`
+	// Pointer because 0 is a valid option and we need to know if the field is missing
+	SelectedOption  *int  `json:"selectedOption"`
+	ClientTimestamp int64 `json:"clientTimestamp,omitempty"`
+}
+
+func (a *SubmitAnswerAction) handle(client *Client) error {
+	if a.SelectedOption == nil {
+		return &ActionError{Code: errorCodeMalformedPayload, Err: errors.New("selectedOption is required")}
+	}
+	room, exists := rooms.Get(a.RoomId)
+	if !exists {
+		return errRoomNotFound
+	}
+	return submitAnswer(room, client.ProfileName, a.QuestionId, *a.SelectedOption, a.ClientTimestamp)
+}
+
+// After the match the players stay together in the room and can play again without going back through matchmaking
+type RematchRequestAction struct {
+	RoomId string `json:"roomId"`
+}
+
+func (a *RematchRequestAction) handle(client *Client) error {
+	room, exists := rooms.Get(a.RoomId)
+	if !exists {
+		return errRoomNotFound
+	}
+	return requestRematch(room, client.ProfileName)
+}
+
+// Accepts the rematch another player of the room asked for
+type RematchAcceptAction struct {
+	RoomId string `json:"roomId"`
+}
+
+func (a *RematchAcceptAction) handle(client *Client) error {
+	room, exists := rooms.Get(a.RoomId)
+	if !exists {
+		return errRoomNotFound
+	}
+	return acceptRematch(room, client.ProfileName)
+}
+
+// Declines the rematch and closes the finished room
+type RematchDeclineAction struct {
+	RoomId string `json:"roomId"`
+}
+
+func (a *RematchDeclineAction) handle(client *Client) error {
+	room, exists := rooms.Get(a.RoomId)
+	if !exists {
+		return errRoomNotFound
+	}
+	declineRematch(room, client.ProfileName)
+	return nil
+}
+
+// Teammates talk to each other, the other team never receives the message
+type TeamChatAction struct {
+	RoomId string `json:"roomId"`
+	Text   string `json:"text"`
+}
+
+func (a *TeamChatAction) handle(client *Client) error {
+	room, exists := rooms.Get(a.RoomId)
+	if !exists {
+		return errRoomNotFound
+	}
+	return sendTeamChat(room, client.ProfileName, a.Text)
+}
+
+// A new socket takes back the seat of the player after the previous socket dropped
+type ResumeAction struct {
+	RoomId      string `json:"roomId"`
+	ResumeToken string `json:"resumeToken"`
+}
+
+func (a *ResumeAction) handle(client *Client) error {
+	return resumeSeat(a.RoomId, client.ProfileName, a.ResumeToken, client.Connection)
+}
+
+// Watch a live room without playing, spectators only receive messages
+type SpectateAction struct {
+	RoomId string `json:"roomId"`
+}
+
+func (a *SpectateAction) handle(client *Client) error {
+	_, err := rooms.Spectate(a.RoomId, client.player())
+	return err
+}
+
+// Stops watching the room the connection spectates
+type StopSpectatingAction struct{}
+
+func (a *StopSpectatingAction) handle(client *Client) error {
+	rooms.StopSpectating(client.Connection)
+	return nil
+}
+
+// Streams a recorded match back event by event with its original timing so players can review it in the room
+type ReplayAction struct {
+	MatchId string `json:"matchId"`
+	// Playback speed, 2 plays the match twice as fast (1 when left out)
+	Speed float64 `json:"speed,omitempty"`
+}
+
+func (a *ReplayAction) handle(client *Client) error {
+	return startReplay(client.Connection, a.MatchId, a.Speed)
+}
+
+// Stops the replay running on the connection
+type StopReplayAction struct{}
+
+func (a *StopReplayAction) handle(client *Client) error {
+	stopReplay(client.Connection)
+	return nil
+}
+
+// When users rage quit or when the game is finished, the user leaves the queue and its room (forfeiting a match in progress)
+type DisconnectAction struct{}
+
+func (a *DisconnectAction) handle(client *Client) error {
+	leaveQueue(client.ProfileName)
+	leaveRoom(client.ProfileName)
+	return nil
+}
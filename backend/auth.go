@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// How long an access token stays valid, short lived because it is verified without a database lookup
+// Clients get a new one from /refresh using the refresh token
+const tokenExpiry = 15 * time.Minute
+
+// Type for the request context key so it can't collide with keys from other packages
+type contextKey string
+
+// Context keys under which authMiddleware stores the profile name and the session of the verified token
+const (
+	profileNameKey contextKey = "profileName"
+	sessionIdKey   contextKey = "sessionId"
+)
+
+// Response sent after a successful login
+type LoginResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+	// Unix seconds when the token expires
+	ExpiresAt        int64  `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt"`
+}
+
+// Creates a signed JWT for the session of the profile which expires after tokenExpiry
+func generateToken(profileName string, sessionId string) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(tokenExpiry).Unix()
+	claims := &Claims{
+		ProfileName: profileName,
+		StandardClaims: jwt.StandardClaims{
+			Id:        sessionId,
+			Subject:   profileName,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt,
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.JWTSecret)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, expiresAt, nil
+}
+
+// Verifies the signature and expiry of the token and returns its claims
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Only accept the algorithm we sign with otherwise a token signed with "none" or another algorithm could pass
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return config.JWTSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.ProfileName == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// Reads the token from the Authorization header ("Bearer <token>")
+// Browsers can't set headers on a websocket upgrade so the token query parameter is accepted as well
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Auth middleware which only lets requests with a valid token through and stores the profile name of the token in the request context
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := tokenFromRequest(r)
+		if tokenString == "" {
+			http.Error(w, "Missing token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := parseToken(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), profileNameKey, claims.ProfileName)
+		ctx = context.WithValue(ctx, sessionIdKey, claims.Id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns the profile name of the verified token stored by authMiddleware
+func profileNameFromContext(ctx context.Context) string {
+	profileName, _ := ctx.Value(profileNameKey).(string)
+	return profileName
+}
+
+// Returns the session of the verified token stored by authMiddleware
+func sessionIdFromContext(ctx context.Context) string {
+	sessionId, _ := ctx.Value(sessionIdKey).(string)
+	return sessionId
+}
+
+// Checks that the profile name sent by the client (if any) is the same as the one in the token
+// Returns the profile name from the token so handlers never trust the name sent in the request
+func authorizedProfileName(w http.ResponseWriter, r *http.Request, requestedProfileName string) (string, bool) {
+	profileName := profileNameFromContext(r.Context())
+	if requestedProfileName != "" && requestedProfileName != profileName {
+		http.Error(w, "Not allowed to access another profile", http.StatusForbidden)
+		return "", false
+	}
+	return profileName, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Settings of the bot opponent used when nobody is found before the queue timeout
+type BotSettings struct {
+	// Pair players with a bot instead of sending queue_timeout
+	Enabled bool
+	// Probability of the bot answering a question correctly (0 to 1)
+	Accuracy float64
+	// The bot answers after a random delay between MinLatency and MaxLatency
+	MinLatency time.Duration
+	MaxLatency time.Duration
+}
+
+// BotProfile holds how a server-side bot plays, a player with a bot profile has no websocket connection
+type BotProfile struct {
+	Accuracy   float64
+	MinLatency time.Duration
+	MaxLatency time.Duration
+}
+
+// Creates a bot player with a name which can't be mistaken for a real profile in the room
+func newBotPlayer(settings BotSettings) (PlayerInfo, error) {
+	suffix, err := generateRandomHex(6)
+	if err != nil {
+		return PlayerInfo{}, err
+	}
+	return PlayerInfo{
+		ProfileName: "Bot-" + suffix,
+		Bot: &BotProfile{
+			Accuracy:   settings.Accuracy,
+			MinLatency: settings.MinLatency,
+			MaxLatency: settings.MaxLatency,
+		},
+	}, nil
+}
+
+// Schedules the answers of the bots in the room for the question that just started
+// The answers go through submitAnswer like the answers of real players so they are scored the same way
+// The caller must hold the room lock
+func (room *Room) scheduleBotAnswers(index int) {
+	question := room.Questions[index]
+	for _, player := range room.Players {
+		if player.Bot == nil {
+			continue
+		}
+		bot := player.Bot
+		profileName := player.ProfileName
+
+		latency := bot.MinLatency
+		if spread := bot.MaxLatency - bot.MinLatency; spread > 0 {
+			latency += time.Duration(rand.Int63n(int64(spread)))
+		}
+		// Pick the correct option with the accuracy of the bot, otherwise any of the wrong options
+		option := question.CorrectOption
+		if rand.Float64() >= bot.Accuracy && len(question.Options) > 1 {
+			option = (question.CorrectOption + 1 + rand.Intn(len(question.Options)-1)) % len(question.Options)
+		}
+
+		time.AfterFunc(latency, func() {
+			if err := submitAnswer(room, profileName, question.ID.Hex(), option, time.Now().UnixMilli()); err != nil {
+				log.Printf("Rejected answer from bot %s in room %s: %v\n", profileName, room.ID, err)
+			}
+		})
+	}
+}
+
+// Pairs a player whose queue timed out with a bot opponent
+func startBotMatch(player PlayerInfo) {
+	bot, err := newBotPlayer(config.Bot)
+	if err != nil {
+		log.Printf("Error creating bot for %s: %v\n", player.ProfileName, err)
+		return
+	}
+	room, err := rooms.Create(modeDuel, false, player, bot)
+	if err != nil {
+		log.Printf("Error creating bot room for %s: %v\n", player.ProfileName, err)
+		return
+	}
+	startMatch(room)
+}
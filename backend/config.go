@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Minimum length of the JWT secret, shorter secrets can be brute forced from a single token
+const minJWTSecretLength = 32
+
+// Config struct to hold every setting of the server
+// Values are read in this order (later ones win): defaults, .env file (optional), environment variables, command-line flags
+type Config struct {
+	// Port the HTTP and websocket server listens on
+	Port string
+	// Frontend origins allowed by CORS
+	AllowedOrigins []string
+
+	MongoURI     string
+	DatabaseName string
+
+	// Secret used to sign the JWTs
+	JWTSecret []byte
+
+	// Maximum number of requests a client can send within RequestWindow
+	RequestLimit  int
+	RequestWindow time.Duration
+
+	CloudinaryCloudName string
+	CloudinaryAPIKey    string
+	CloudinaryAPISecret string
+
+	// Skill based matchmaking (rating search window)
+	Matchmaking MatchmakingSettings
+	// Bot opponent for players whose queue timed out
+	Bot BotSettings
+	// Free-for-all matchmaking (3 to 8 players)
+	FreeForAll FreeForAllSettings
+	// How long the seat of a player whose socket dropped is held for a resume
+	ResumeGracePeriod time.Duration
+	// Queue cooldown for players who keep abandoning ranked matches
+	Abandonment AbandonmentSettings
+}
+
+// Config used by the whole server, loaded once in main
+var config *Config
+
+// Loads the config from the .env file, environment variables and command-line flags and validates it
+func loadConfig(args []string) (*Config, error) {
+	// The .env file is optional (production sets real environment variables) and never overrides variables that are already set
+	envFile := envOrDefault("ENV_FILE", ".env")
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("loading %s: %w", envFile, err)
+	}
+
+	requestLimit, err := envInt("RATE_LIMIT_REQUESTS", 1000)
+	if err != nil {
+		return nil, err
+	}
+	requestWindow, err := envDuration("RATE_LIMIT_WINDOW", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	matchBaseWindow, err := envInt("MATCH_RATING_WINDOW", 50)
+	if err != nil {
+		return nil, err
+	}
+	matchWindowGrowth, err := envInt("MATCH_RATING_WINDOW_GROWTH", 50)
+	if err != nil {
+		return nil, err
+	}
+	matchWindowInterval, err := envDuration("MATCH_RATING_WINDOW_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	matchMaxWait, err := envDuration("MATCH_MAX_WAIT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	queueTimeout, err := envDuration("QUEUE_TIMEOUT", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	botAccuracy, err := envFloat("BOT_ACCURACY", 0.6)
+	if err != nil {
+		return nil, err
+	}
+	botMinLatency, err := envDuration("BOT_MIN_LATENCY", 1500*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	botMaxLatency, err := envDuration("BOT_MAX_LATENCY", 4*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	ffaMinPlayers, err := envInt("FFA_MIN_PLAYERS", 3)
+	if err != nil {
+		return nil, err
+	}
+	ffaMaxPlayers, err := envInt("FFA_MAX_PLAYERS", 8)
+	if err != nil {
+		return nil, err
+	}
+	ffaStartAfter, err := envDuration("FFA_START_AFTER", 20*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	resumeGracePeriod, err := envDuration("RESUME_GRACE_PERIOD", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	abandonLimit, err := envInt("ABANDON_LIMIT", 2)
+	if err != nil {
+		return nil, err
+	}
+	abandonWindow, err := envDuration("ABANDON_WINDOW", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	abandonCooldown, err := envDuration("ABANDON_COOLDOWN", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	var allowedOrigins, jwtSecret string
+	flagSet := flag.NewFlagSet("server", flag.ContinueOnError)
+	flagSet.StringVar(&cfg.Port, "port", envOrDefault("PORT", "5000"), "port to listen on (PORT)")
+	flagSet.StringVar(&allowedOrigins, "allowed-origins", envOrDefault("ALLOWED_ORIGINS", "http://localhost:5173"), "comma separated CORS origins (ALLOWED_ORIGINS)")
+	// Secrets have no flag default so -h never prints them, the environment variable is used when the flag is not passed
+	flagSet.StringVar(&cfg.MongoURI, "mongo-uri", "", "MongoDB connection URI (MONGO_CONNECTION_URI)")
+	flagSet.StringVar(&cfg.DatabaseName, "database", envOrDefault("DATABASE_NAME", "quiz"), "MongoDB database name (DATABASE_NAME)")
+	flagSet.StringVar(&jwtSecret, "jwt-secret", "", "secret used to sign JWTs, prefer the JWT_SECRET environment variable")
+	flagSet.IntVar(&cfg.RequestLimit, "rate-limit", requestLimit, "maximum requests per client within the rate limit window (RATE_LIMIT_REQUESTS)")
+	flagSet.DurationVar(&cfg.RequestWindow, "rate-limit-window", requestWindow, "rate limit window (RATE_LIMIT_WINDOW)")
+	flagSet.StringVar(&cfg.CloudinaryCloudName, "cloudinary-cloud-name", os.Getenv("CLOUDINARY_CLOUD_NAME"), "Cloudinary cloud name (CLOUDINARY_CLOUD_NAME)")
+	flagSet.StringVar(&cfg.CloudinaryAPIKey, "cloudinary-api-key", os.Getenv("CLOUDINARY_API_KEY"), "Cloudinary API key (CLOUDINARY_API_KEY)")
+	flagSet.StringVar(&cfg.CloudinaryAPISecret, "cloudinary-api-secret", "", "Cloudinary API secret (CLOUDINARY_API_SECRET)")
+	flagSet.IntVar(&cfg.Matchmaking.BaseWindow, "match-rating-window", matchBaseWindow, "rating difference allowed when a player joins the queue (MATCH_RATING_WINDOW)")
+	flagSet.IntVar(&cfg.Matchmaking.WindowGrowth, "match-rating-window-growth", matchWindowGrowth, "rating added to the window every interval (MATCH_RATING_WINDOW_GROWTH)")
+	flagSet.DurationVar(&cfg.Matchmaking.WindowInterval, "match-rating-window-interval", matchWindowInterval, "how often the rating window grows (MATCH_RATING_WINDOW_INTERVAL)")
+	flagSet.DurationVar(&cfg.Matchmaking.MaxWait, "match-max-wait", matchMaxWait, "wait after which any opponent is accepted (MATCH_MAX_WAIT)")
+	flagSet.DurationVar(&cfg.Matchmaking.QueueTimeout, "queue-timeout", queueTimeout, "wait after which a player leaves the queue (QUEUE_TIMEOUT)")
+	flagSet.BoolVar(&cfg.Bot.Enabled, "bot-fallback", os.Getenv("BOT_FALLBACK") == "true", "pair players with a bot after the queue timeout (BOT_FALLBACK)")
+	flagSet.Float64Var(&cfg.Bot.Accuracy, "bot-accuracy", botAccuracy, "probability of the bot answering correctly (BOT_ACCURACY)")
+	flagSet.DurationVar(&cfg.Bot.MinLatency, "bot-min-latency", botMinLatency, "minimum time the bot takes to answer (BOT_MIN_LATENCY)")
+	flagSet.DurationVar(&cfg.Bot.MaxLatency, "bot-max-latency", botMaxLatency, "maximum time the bot takes to answer (BOT_MAX_LATENCY)")
+	flagSet.IntVar(&cfg.FreeForAll.MinPlayers, "ffa-min-players", ffaMinPlayers, "fewest players a free-for-all match starts with (FFA_MIN_PLAYERS)")
+	flagSet.IntVar(&cfg.FreeForAll.MaxPlayers, "ffa-max-players", ffaMaxPlayers, "players a free-for-all match starts with right away (FFA_MAX_PLAYERS)")
+	flagSet.DurationVar(&cfg.FreeForAll.StartAfter, "ffa-start-after", ffaStartAfter, "wait after which a free-for-all match starts with fewer players (FFA_START_AFTER)")
+	flagSet.DurationVar(&cfg.ResumeGracePeriod, "resume-grace-period", resumeGracePeriod, "how long the seat of a dropped player is held (RESUME_GRACE_PERIOD)")
+	flagSet.IntVar(&cfg.Abandonment.Limit, "abandon-limit", abandonLimit, "abandoned ranked matches within the window that start a queue cooldown (ABANDON_LIMIT)")
+	flagSet.DurationVar(&cfg.Abandonment.Window, "abandon-window", abandonWindow, "window in which abandoned matches are counted (ABANDON_WINDOW)")
+	flagSet.DurationVar(&cfg.Abandonment.Cooldown, "abandon-cooldown", abandonCooldown, "how long repeat abandoners can't queue (ABANDON_COOLDOWN)")
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.MongoURI = firstNonEmpty(cfg.MongoURI, os.Getenv("MONGO_CONNECTION_URI"))
+	jwtSecret = firstNonEmpty(jwtSecret, os.Getenv("JWT_SECRET"))
+	cfg.CloudinaryAPISecret = firstNonEmpty(cfg.CloudinaryAPISecret, os.Getenv("CLOUDINARY_API_SECRET"))
+
+	cfg.AllowedOrigins = splitList(allowedOrigins)
+	cfg.JWTSecret = []byte(jwtSecret)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Checks the config so a misconfigured server fails on startup instead of on the first request
+func (cfg *Config) validate() error {
+	var problems []string
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("invalid port %q", cfg.Port))
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		problems = append(problems, "at least one allowed origin is required")
+	}
+	if cfg.MongoURI == "" {
+		problems = append(problems, "MONGO_CONNECTION_URI is not set")
+	}
+	if cfg.DatabaseName == "" {
+		problems = append(problems, "database name is empty")
+	}
+	if len(cfg.JWTSecret) < minJWTSecretLength {
+		problems = append(problems, fmt.Sprintf("JWT_SECRET must be at least %d characters", minJWTSecretLength))
+	}
+	if cfg.RequestLimit <= 0 {
+		problems = append(problems, "rate limit must be positive")
+	}
+	if cfg.RequestWindow <= 0 {
+		problems = append(problems, "rate limit window must be positive")
+	}
+	if cfg.Matchmaking.BaseWindow < 0 || cfg.Matchmaking.WindowGrowth < 0 {
+		problems = append(problems, "matchmaking rating windows can't be negative")
+	}
+	if cfg.Matchmaking.WindowInterval <= 0 || cfg.Matchmaking.MaxWait <= 0 || cfg.Matchmaking.QueueTimeout <= 0 {
+		problems = append(problems, "matchmaking intervals must be positive")
+	}
+	if cfg.Bot.Accuracy < 0 || cfg.Bot.Accuracy > 1 {
+		problems = append(problems, "bot accuracy must be between 0 and 1")
+	}
+	if cfg.Bot.MinLatency < 0 || cfg.Bot.MinLatency > cfg.Bot.MaxLatency {
+		problems = append(problems, "bot latency range is invalid")
+	}
+	if cfg.FreeForAll.MinPlayers < 3 || cfg.FreeForAll.MinPlayers > cfg.FreeForAll.MaxPlayers || cfg.FreeForAll.MaxPlayers > maxRoomPlayers {
+		problems = append(problems, fmt.Sprintf("free-for-all rooms must be for 3 to %d players", maxRoomPlayers))
+	}
+	if cfg.FreeForAll.StartAfter <= 0 {
+		problems = append(problems, "free-for-all start wait must be positive")
+	}
+	if cfg.ResumeGracePeriod <= 0 {
+		problems = append(problems, "resume grace period must be positive")
+	}
+	if cfg.Abandonment.Limit < 1 || cfg.Abandonment.Window <= 0 || cfg.Abandonment.Cooldown < 0 {
+		problems = append(problems, "abandonment limit and window must be positive")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Returns the environment variable or the fallback when it is not set
+func envOrDefault(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Returns the first value which is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Reads an integer environment variable
+func envInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// Reads a floating point environment variable
+func envFloat(key string, fallback float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// Reads a duration environment variable (like "60s" or "1m")
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// Splits a comma separated list and drops the empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
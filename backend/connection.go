@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// The client must answer a ping (or send a message) within this time or the connection is considered dead
+	pongWait = 60 * time.Second
+	// Pings are sent a bit more often than pongWait so a healthy client never times out
+	pingPeriod = pongWait * 9 / 10
+	// Largest message accepted from the client
+	maxMessageSize = 4096
+	// Messages waiting for the writer, a client which falls this far behind is disconnected
+	sendBufferSize = 64
+)
+
+var (
+	errConnectionClosed = errors.New("connection is closed")
+	errSlowConsumer     = errors.New("client is not reading its messages")
+)
+
+// Connection is a websocket connection with a dedicated writer goroutine
+// gorilla/websocket allows only one concurrent writer so every goroutine (the player's own handler, the opponent's handler, the question timer, bots) queues its messages on the send channel instead of writing to the socket
+type Connection struct {
+	ws   *websocket.Conn
+	send chan Envelope
+	// Seq of the last message sent, only used by the writer goroutine
+	seq uint64
+	// Closed once to stop the writer, which then closes the socket
+	closed    chan struct{}
+	closeOnce sync.Once
+	// Close frame sent by the writer before it closes the socket
+	closeCode int
+	closeText string
+}
+
+// Wraps the upgraded websocket, sets the read limits and deadlines and starts the writer goroutine
+func newConnection(ws *websocket.Conn) *Connection {
+	conn := &Connection{
+		ws:        ws,
+		send:      make(chan Envelope, sendBufferSize),
+		closed:    make(chan struct{}),
+		closeCode: websocket.CloseNormalClosure,
+	}
+	ws.SetReadLimit(maxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	// Every pong proves the client is still there
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go conn.writePump()
+	return conn
+}
+
+// Send wraps the payload in an envelope of the message type and queues it for the writer goroutine, it never blocks
+// The payload is encoded right away so the caller can change its data afterwards, a nil payload is left out
+// A client whose send buffer is full is disconnected instead of slowing down the room
+func (c *Connection) Send(messageType string, payload interface{}) error {
+	envelope := Envelope{Type: messageType, Version: protocolVersion}
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		envelope.Payload = encoded
+	}
+	select {
+	case <-c.closed:
+		return errConnectionClosed
+	default:
+	}
+	select {
+	case c.send <- envelope:
+		return nil
+	default:
+		log.Printf("Closing slow websocket client %s\n", c.ws.RemoteAddr())
+		c.CloseWithReason(websocket.CloseTryAgainLater, "too many pending messages")
+		return errSlowConsumer
+	}
+}
+
+// ReadMessage reads the next message of the client
+// Reading (or a pong) extends the read deadline so only a silent client times out
+func (c *Connection) ReadMessage() ([]byte, error) {
+	_, message, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	return message, nil
+}
+
+// Close stops the writer which sends a close frame and closes the socket
+// The reader then fails so the handler of the connection cleans up on its own
+func (c *Connection) Close() {
+	c.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason closes the connection with the given close code, only the first close counts
+func (c *Connection) CloseWithReason(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.closed)
+	})
+}
+
+// Writes the queued messages and the pings, it is the only goroutine writing to the socket
+func (c *Connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case envelope := <-c.send:
+			// Numbered here so the seq follows the order the client receives the messages in
+			c.seq++
+			envelope.Seq = c.seq
+			message, err := json.Marshal(envelope)
+			if err != nil {
+				log.Printf("Error encoding %s message: %v\n", envelope.Type, err)
+				continue
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, message); err != nil {
+				c.Close()
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.closed:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Settings of the free-for-all matchmaking
+type FreeForAllSettings struct {
+	// A free-for-all match needs at least MinPlayers and starts right away with MaxPlayers
+	MinPlayers int
+	MaxPlayers int
+	// Once the longest waiting player waited this long the match starts with everyone waiting (at least MinPlayers)
+	StartAfter time.Duration
+}
+
+// Sent to every player in the room after every question with the places so far
+type StandingsMessage struct {
+	QuestionIndex int        `json:"questionIndex"`
+	Standings     []Standing `json:"standings"`
+	// Points of every team, only sent in team matches
+	TeamPoints map[int]uint16 `json:"teamPoints,omitempty"`
+}
+
+// Free-for-all lobby used by the websocket handlers, created in main from the config
+var ffaLobby *GroupLobby
+
+// Creates the room for a group of players from the lobby and starts the match
+func createFreeForAllMatch(players []PlayerInfo) {
+	room, err := rooms.Create(modeFreeForAll, true, players...)
+	if err != nil {
+		log.Printf("Error creating free-for-all room for %d players: %v\n", len(players), err)
+		return
+	}
+	startMatch(room)
+}
+
+// Called for the players who waited in the lobby longer than the queue timeout
+// With the bot fallback they play an unranked match with bots filling the missing places, otherwise they get queue_timeout
+func ffaLobbyTimedOut(players []PlayerInfo) {
+	if !config.Bot.Enabled {
+		sendQueueTimeout(players)
+		return
+	}
+	players, err := fillWithBots(players, config.FreeForAll.MinPlayers)
+	if err != nil {
+		log.Printf("Error creating bot for free-for-all room: %v\n", err)
+		return
+	}
+	room, err := rooms.Create(modeFreeForAll, false, players...)
+	if err != nil {
+		log.Printf("Error creating free-for-all bot room: %v\n", err)
+		return
+	}
+	startMatch(room)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Trophies lost on top of a normal loss for abandoning a ranked match
+	forfeitTrophyPenalty = 5
+	// Number of recent abandonments kept on the profile
+	abandonmentHistoryLength = 10
+)
+
+// Settings of the queue cooldown for players who keep abandoning ranked matches
+type AbandonmentSettings struct {
+	// Players who abandoned Limit ranked matches within Window can't queue for Cooldown after the last one
+	Limit    int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// Sent to a player who tries to queue (or to take a seat in a ranked private room) during its cooldown
+type QueueCooldownMessage struct {
+	// Unix milliseconds when the player can queue again
+	Until int64 `json:"until"`
+}
+
+// Ends the match for the player who abandoned it (disconnect action or not back within the resume grace period)
+// In a duel or a team match the other side wins, in a free-for-all match only the player leaves and the others keep playing
+// Returns false when the match of the room is not in progress so the caller leaves the room normally
+func forfeitMatch(room *Room, profileName string) bool {
+	if result, forfeited := rooms.ForfeitFreeForAll(room, profileName); forfeited {
+		updateAchievementData(result)
+		return true
+	}
+
+	room.mu.Lock()
+	if !room.inProgress() || room.player(profileName) == nil {
+		room.mu.Unlock()
+		return false
+	}
+
+	result := room.forfeitResult(profileName)
+	room.Completed = true
+	room.Closed = true
+	resultMessage := MatchResultMessage{
+		Winner:      result.Winner,
+		Points:      make(map[string][]uint16),
+		Standings:   result.Standings,
+		WinningTeam: result.WinningTeam,
+		TeamPoints:  result.TeamPoints,
+		ForfeitedBy: profileName,
+	}
+	for i := range room.Players {
+		resultMessage.Points[room.Players[i].ProfileName] = pointsProgression(&room.Players[i])
+	}
+	room.broadcast("match_result", resultMessage)
+	record := room.matchRecord(result)
+	room.mu.Unlock()
+
+	go saveMatchRecord(record)
+	updateAchievementData(result)
+	rooms.Complete(room.ID)
+	return true
+}
+
+// ForfeitFreeForAll takes the player out of a running free-for-all match which goes on without it and returns its forfeit result
+// Deciding that the match goes on and removing the player happen under both locks, otherwise two players leaving at the same time could both stay seated after their forfeit
+// Returns false when the match can't go on without the player so the caller ends it instead
+func (m *RoomManager) ForfeitFreeForAll(room *Room, profileName string) (MatchResult, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if m.profileRooms[profileName] != room.ID || !room.inProgress() || room.player(profileName) == nil || !room.canRemovePlayer() {
+		return MatchResult{}, false
+	}
+	result := room.forfeitResult(profileName)
+	for _, player := range room.Players {
+		if player.ProfileName != profileName {
+			result.StillPlaying[player.ProfileName] = true
+		}
+	}
+	room.broadcast("player_forfeited", PlayerConnectionMessage{ProfileName: profileName})
+	// The record of the player is saved with the match record once the others finish
+	for _, standing := range result.Standings {
+		if standing.ProfileName == profileName {
+			room.Departed = append(room.Departed, matchPlayerRecord(room.player(profileName), standing, result))
+		}
+	}
+	m.removePlayerLocked(room, profileName)
+	return result, true
+}
+
+// Builds the result of a match abandoned by the player
+// The player (and its team) finishes last no matter the points, nobody unlocks achievements in an unfinished match
+// The caller must hold the room lock
+func (room *Room) forfeitResult(profileName string) MatchResult {
+	result := MatchResult{
+		Mode:              room.Mode,
+		PerfectScore:      make(map[string]bool),
+		LightningReflexes: make(map[string]bool),
+		Ranked:            room.Ranked,
+		Bots:              make(map[string]bool),
+		ForfeitedBy:       profileName,
+		StillPlaying:      make(map[string]bool),
+		MatchId:           room.MatchId,
+	}
+	for _, player := range room.Players {
+		if player.Bot != nil {
+			result.Bots[player.ProfileName] = true
+		}
+	}
+	for _, departed := range room.Departed {
+		result.Departed = append(result.Departed, departed.ProfileName)
+	}
+	forfeitingTeam := room.player(profileName).Team
+
+	// Keep the order of the points but move the player (and its team) behind everyone else
+	var winners, losers []Standing
+	for _, standing := range room.standings() {
+		if standing.ProfileName == profileName || (room.Mode == modeTeams && standing.Team == forfeitingTeam) {
+			losers = append(losers, standing)
+		} else {
+			winners = append(winners, standing)
+		}
+	}
+	for i := range winners {
+		winners[i].Place = 1
+		if result.Ranked {
+			winners[i].Trophies = trophiesForWin
+		}
+	}
+	for i := range losers {
+		losers[i].Place = len(winners) + 1
+		if result.Ranked {
+			losers[i].Trophies = trophiesForLoss
+			if losers[i].ProfileName == profileName {
+				losers[i].Trophies -= forfeitTrophyPenalty
+			}
+		}
+	}
+	result.Standings = append(winners, losers...)
+
+	if room.Mode == modeTeams {
+		result.WinningTeam = 3 - forfeitingTeam
+		result.TeamPoints = room.teamPoints()
+	} else if len(winners) == 1 {
+		result.Winner = winners[0].ProfileName
+	}
+	return result
+}
+
+// Returns when the player can queue again after abandoning too many ranked matches, zero time when it can queue now
+func queueCooldownUntil(ctx context.Context, profileName string) (time.Time, error) {
+	var profile struct {
+		Abandonments []time.Time `bson:"abandonments"`
+	}
+	err := collection.FindOne(ctx, bson.M{"profileName": profileName}, options.FindOne().SetProjection(bson.M{"abandonments": 1, "_id": 0})).Decode(&profile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	recent := 0
+	var last time.Time
+	for _, abandonedAt := range profile.Abandonments {
+		if now.Sub(abandonedAt) <= config.Abandonment.Window {
+			recent++
+		}
+		if abandonedAt.After(last) {
+			last = abandonedAt
+		}
+	}
+	if recent < config.Abandonment.Limit {
+		return time.Time{}, nil
+	}
+	if until := last.Add(config.Abandonment.Cooldown); until.After(now) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// Checks the queue cooldown of the player and tells it when it can queue again
+// Returns false when the player has to wait
+func checkQueueCooldown(player PlayerInfo) bool {
+	until, err := queueCooldownUntil(context.TODO(), player.ProfileName)
+	if err != nil {
+		// Don't lock players out of the queue because the cooldown could not be read
+		log.Printf("Error reading queue cooldown of %s: %v\n", player.ProfileName, err)
+		return true
+	}
+	if until.IsZero() {
+		return true
+	}
+	if err := player.send("queue_cooldown", QueueCooldownMessage{Until: until.UnixMilli()}); err != nil {
+		log.Printf("Error sending queue cooldown to %s\n", player.ProfileName)
+	}
+	return false
+}
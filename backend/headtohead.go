@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Number of recent matches sent with the head-to-head statistics
+const headToHeadRecentMatches = 10
+
+// Record of one of the two players against the other
+type HeadToHeadPlayer struct {
+	ProfileName string `json:"profileName"`
+	Wins        int    `json:"wins"`
+	Losses      int    `json:"losses"`
+	// Average points per match against the other player
+	AverageScore float64 `json:"averageScore"`
+	// Fastest correct answer in milliseconds, 0 when the player never answered correctly
+	FastestAnswer int64 `json:"fastestAnswer"`
+}
+
+// One match the two players played against each other
+type HeadToHeadMatch struct {
+	MatchId  string    `json:"matchId"`
+	Mode     string    `json:"mode"`
+	PlayedAt time.Time `json:"playedAt"`
+	// Profile name of the player who finished ahead, empty for a draw
+	Winner string            `json:"winner,omitempty"`
+	Scores map[string]uint16 `json:"scores"`
+}
+
+// Statistics of every match two players played against each other
+type HeadToHead struct {
+	Matches int              `json:"matches"`
+	Draws   int              `json:"draws"`
+	A       HeadToHeadPlayer `json:"a"`
+	B       HeadToHeadPlayer `json:"b"`
+	// Newest first
+	RecentMatches []HeadToHeadMatch `json:"recentMatches"`
+}
+
+// Place and points of one of the two players in a recent match
+type headToHeadStanding struct {
+	Place  int    `bson:"place"`
+	Points uint16 `bson:"points"`
+}
+
+// Record of the player with the profile name among the players of the match
+func headToHeadPlayerRecord(profileName string) bson.M {
+	return bson.M{"$arrayElemAt": bson.A{
+		bson.M{"$filter": bson.M{"input": "$players", "cond": bson.M{"$eq": bson.A{"$$this.profileName", profileName}}}},
+		0,
+	}}
+}
+
+// Keeps only the place, the points and the fastest correct answer (in nanoseconds like time.Duration) of the record of the player
+func headToHeadStandingProjection(player string) bson.M {
+	return bson.M{
+		"place":  "$" + player + ".place",
+		"points": "$" + player + ".points",
+		"fastest": bson.M{"$min": bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{"input": "$" + player + ".answers", "cond": "$$this.isCorrect"}},
+			"in":    "$$this.timeTaken",
+		}}},
+	}
+}
+
+// Stages matching the matches the two players played against each other, with their records as a and b
+// The first stage uses the players.profileName/endedAt index, sortByDate sorts right after it so the index also gives the order
+// Teammates in a team match did not play against each other so those matches are left out
+func headToHeadStages(a string, b string, sortByDate bool) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"players.profileName": bson.M{"$all": bson.A{a, b}}}}},
+	}
+	if sortByDate {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.M{"endedAt": -1}}})
+	}
+	return append(pipeline,
+		bson.D{{Key: "$project", Value: bson.M{
+			"mode":    1,
+			"endedAt": 1,
+			"a":       headToHeadPlayerRecord(a),
+			"b":       headToHeadPlayerRecord(b),
+		}}},
+		bson.D{{Key: "$match", Value: bson.M{"$expr": bson.M{"$not": bson.A{bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$mode", modeTeams}},
+			bson.M{"$eq": bson.A{"$a.team", "$b.team"}},
+		}}}}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"mode":    1,
+			"endedAt": 1,
+			"a":       headToHeadStandingProjection("a"),
+			"b":       headToHeadStandingProjection("b"),
+		}}},
+	)
+}
+
+// Computes the statistics of the two players from the records of the matches they played against each other
+// The totals are grouped by the database and only the recent matches are read, matches played before match records were saved are left out
+func computeHeadToHead(ctx context.Context, a string, b string) (HeadToHead, error) {
+	headToHead := HeadToHead{
+		A:             HeadToHeadPlayer{ProfileName: a},
+		B:             HeadToHeadPlayer{ProfileName: b},
+		RecentMatches: []HeadToHeadMatch{},
+	}
+	winsOf := func(player string, opponent string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$lt": bson.A{"$" + player + ".place", "$" + opponent + ".place"}}, 1, 0}}}
+	}
+	totals := append(headToHeadStages(a, b, false), bson.D{{Key: "$group", Value: bson.M{
+		"_id":      nil,
+		"matches":  bson.M{"$sum": 1},
+		"draws":    bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$a.place", "$b.place"}}, 1, 0}}},
+		"winsA":    winsOf("a", "b"),
+		"winsB":    winsOf("b", "a"),
+		"averageA": bson.M{"$avg": "$a.points"},
+		"averageB": bson.M{"$avg": "$b.points"},
+		// $min skips the matches without a correct answer
+		"fastestA": bson.M{"$min": "$a.fastest"},
+		"fastestB": bson.M{"$min": "$b.fastest"},
+	}}})
+	cursor, err := matchesCollection.Aggregate(ctx, totals)
+	if err != nil {
+		return headToHead, err
+	}
+	var groups []struct {
+		Matches  int     `bson:"matches"`
+		Draws    int     `bson:"draws"`
+		WinsA    int     `bson:"winsA"`
+		WinsB    int     `bson:"winsB"`
+		AverageA float64 `bson:"averageA"`
+		AverageB float64 `bson:"averageB"`
+		FastestA *int64  `bson:"fastestA"`
+		FastestB *int64  `bson:"fastestB"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return headToHead, err
+	}
+	// No group at all when the players never played against each other
+	if len(groups) == 0 {
+		return headToHead, nil
+	}
+	fastest := func(nanoseconds *int64) int64 {
+		if nanoseconds == nil {
+			return 0
+		}
+		return time.Duration(*nanoseconds).Milliseconds()
+	}
+	group := groups[0]
+	headToHead.Matches = group.Matches
+	headToHead.Draws = group.Draws
+	headToHead.A.Wins, headToHead.A.Losses = group.WinsA, group.WinsB
+	headToHead.B.Wins, headToHead.B.Losses = group.WinsB, group.WinsA
+	headToHead.A.AverageScore, headToHead.B.AverageScore = group.AverageA, group.AverageB
+	headToHead.A.FastestAnswer, headToHead.B.FastestAnswer = fastest(group.FastestA), fastest(group.FastestB)
+
+	recent := append(headToHeadStages(a, b, true), bson.D{{Key: "$limit", Value: headToHeadRecentMatches}})
+	cursor, err = matchesCollection.Aggregate(ctx, recent)
+	if err != nil {
+		return headToHead, err
+	}
+	var matches []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Mode    string             `bson:"mode"`
+		EndedAt time.Time          `bson:"endedAt"`
+		A       headToHeadStanding `bson:"a"`
+		B       headToHeadStanding `bson:"b"`
+	}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return headToHead, err
+	}
+	for _, record := range matches {
+		match := HeadToHeadMatch{
+			MatchId:  record.ID.Hex(),
+			Mode:     record.Mode,
+			PlayedAt: record.EndedAt,
+			Scores:   map[string]uint16{a: record.A.Points, b: record.B.Points},
+		}
+		if record.A.Place < record.B.Place {
+			match.Winner = a
+		} else if record.B.Place < record.A.Place {
+			match.Winner = b
+		}
+		headToHead.RecentMatches = append(headToHead.RecentMatches, match)
+	}
+	return headToHead, nil
+}
+
+// Getting the head-to-head statistics of two players (a and b query parameters)
+func getHeadToHead(w http.ResponseWriter, r *http.Request) {
+	a := r.URL.Query().Get("a")
+	b := r.URL.Query().Get("b")
+	if a == "" || b == "" || a == b {
+		http.Error(w, "Two different profile names are required", http.StatusBadRequest)
+		return
+	}
+	headToHead, err := computeHeadToHead(r.Context(), a, b)
+	if err != nil {
+		http.Error(w, "Failed to compute head-to-head statistics", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(headToHead); err != nil {
+		http.Error(w, "Failed to encode head-to-head statistics", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Size of a history page when the client does not ask for one, and the largest page it can ask for
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100
+)
+
+// One page of the history of a profile, newest match first
+type HistoryPage struct {
+	History []HistoryItem `json:"history"`
+	// Sent back as the cursor parameter to get the next page, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Filters and position of a history page read from the query parameters
+type historyQuery struct {
+	Result   string
+	Opponent string
+	From     time.Time
+	To       time.Time
+	// Only entries before this position in the history array, -1 for the first page
+	Before int64
+	Limit  int
+}
+
+// Reads the history query from the query parameters
+// Dates are RFC 3339 timestamps or plain days (2006-01-02), a plain day for to includes the whole day
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
+	values := r.URL.Query()
+	query := historyQuery{
+		Result:   values.Get("result"),
+		Opponent: values.Get("opponent"),
+		Before:   -1,
+		Limit:    defaultHistoryPageSize,
+	}
+	switch query.Result {
+	case "", "Won", "Lost", "Draw", "Forfeit":
+	default:
+		return query, errors.New("result must be Won, Lost, Draw or Forfeit")
+	}
+	parseDate := func(name string, endOfDay bool) (time.Time, error) {
+		value := values.Get(name)
+		if value == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, errors.New(name + " must be an RFC 3339 timestamp or a date")
+		}
+		if endOfDay {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+	var err error
+	if query.From, err = parseDate("from", false); err != nil {
+		return query, err
+	}
+	if query.To, err = parseDate("to", true); err != nil {
+		return query, err
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		if query.Before, err = strconv.ParseInt(cursor, 10, 64); err != nil || query.Before < 0 {
+			return query, errors.New("cursor is not valid")
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > maxHistoryPageSize {
+			return query, errors.New("limit must be between 1 and 100")
+		}
+	}
+	return query, nil
+}
+
+// Reads one page of the history of the profile
+// History entries are only ever appended so their position in the array orders them (older entries have no timestamp) and is used as the cursor
+func findHistoryPage(ctx context.Context, profileName string, query historyQuery) (HistoryPage, error) {
+	filter := bson.M{}
+	if query.Result != "" {
+		filter["history.result"] = query.Result
+	}
+	if query.Opponent != "" {
+		// Duels save the opponent, free-for-all and team matches every other player
+		filter["$or"] = bson.A{
+			bson.M{"history.opponent": query.Opponent},
+			bson.M{"history.opponents": query.Opponent},
+		}
+	}
+	playedAt := bson.M{}
+	if !query.From.IsZero() {
+		playedAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		playedAt["$lt"] = query.To
+	}
+	if len(playedAt) > 0 {
+		filter["history.playedAt"] = playedAt
+	}
+	if query.Before >= 0 {
+		filter["index"] = bson.M{"$lt": query.Before}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"profileName": profileName}}},
+		{{Key: "$project", Value: bson.M{"history": 1, "_id": 0}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$history", "includeArrayIndex": "index"}}},
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"index": -1}}},
+		// One more than the page to know if there is a next page
+		{{Key: "$limit", Value: query.Limit + 1}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	var entries []struct {
+		History HistoryItem `bson:"history"`
+		Index   int64       `bson:"index"`
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return HistoryPage{}, err
+	}
+
+	page := HistoryPage{History: []HistoryItem{}}
+	for i, entry := range entries {
+		if i == query.Limit {
+			page.NextCursor = strconv.FormatInt(entries[i-1].Index, 10)
+			break
+		}
+		page.History = append(page.History, entry.History)
+	}
+	return page, nil
+}
+
+// Getting History data
+// Returns one page of the history newest first, filtered by result, opponent and date range
+func getHistoryData(w http.ResponseWriter, r *http.Request) {
+	// Defaults to the profile of the token when the profile name parameter is missing
+	profileName, ok := authorizedProfileName(w, r, r.URL.Query().Get("profileName"))
+	if !ok {
+		return
+	}
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := findHistoryPage(r.Context(), profileName, query)
+	if err != nil {
+		http.Error(w, "Failed to decode history data", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, "Failed to encode history data", http.StatusInternalServerError)
+		return
+	}
+}
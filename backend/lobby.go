@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// GroupLobby holds the players waiting for a match with more than two players (free-for-all and team matches)
+// Players are grouped in joining order, ratings are not used since a match mixes many players anyway (team matches balance the teams by rating when the match starts)
+type GroupLobby struct {
+	mu sync.Mutex
+	// A match needs at least minPlayers and starts right away with maxPlayers
+	minPlayers int
+	maxPlayers int
+	// Once the longest waiting player waited this long the match starts with everyone waiting (at least minPlayers)
+	startAfter time.Duration
+	// Queue timeout shared with the duel matchmaking
+	timeout time.Duration
+	waiting []queueEntry
+}
+
+// Creates an empty lobby
+func NewGroupLobby(minPlayers int, maxPlayers int, startAfter time.Duration, timeout time.Duration) *GroupLobby {
+	return &GroupLobby{
+		minPlayers: minPlayers,
+		maxPlayers: maxPlayers,
+		startAfter: startAfter,
+		timeout:    timeout,
+	}
+}
+
+// Returns the index of the waiting profile or -1
+// The caller must hold the lobby lock
+func (l *GroupLobby) indexOf(profileName string) int {
+	for i, entry := range l.waiting {
+		if entry.Player.ProfileName == profileName {
+			return i
+		}
+	}
+	return -1
+}
+
+// Takes the first count players out of the lobby
+// The caller must hold the lobby lock
+func (l *GroupLobby) takeLocked(count int) []PlayerInfo {
+	players := make([]PlayerInfo, count)
+	for i := range players {
+		players[i] = l.waiting[i].Player
+	}
+	l.waiting = append([]queueEntry(nil), l.waiting[count:]...)
+	return players
+}
+
+// Join adds the player to the lobby and returns the full group when the player was the last one missing
+// Otherwise the position of the player in the lobby is returned
+func (l *GroupLobby) Join(player PlayerInfo) (group []PlayerInfo, position int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.indexOf(player.ProfileName) >= 0 {
+		return nil, 0, errAlreadyQueued
+	}
+	l.waiting = append(l.waiting, queueEntry{Player: player, JoinedAt: time.Now()})
+	if len(l.waiting) >= l.maxPlayers {
+		return l.takeLocked(l.maxPlayers), 0, nil
+	}
+	return nil, len(l.waiting), nil
+}
+
+// Ready returns the waiting players as a group once the longest waiting player waited startAfter and enough players joined
+func (l *GroupLobby) Ready() []PlayerInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.waiting) < l.minPlayers || time.Since(l.waiting[0].JoinedAt) < l.startAfter {
+		return nil
+	}
+	return l.takeLocked(min(len(l.waiting), l.maxPlayers))
+}
+
+// Expire removes the players who waited longer than the queue timeout without enough players joining
+func (l *GroupLobby) Expire() []PlayerInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The lobby is in joining order so every expired player is at the front
+	count := 0
+	for count < len(l.waiting) && time.Since(l.waiting[count].JoinedAt) >= l.timeout {
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return l.takeLocked(count)
+}
+
+// Starts the matches of the groups that are ready every second and removes the players who waited too long
+func (l *GroupLobby) Run(onGroup func(players []PlayerInfo), onTimeout func(players []PlayerInfo)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		group := l.Ready()
+		if group != nil {
+			onGroup(group)
+		}
+		expired := l.Expire()
+		if expired != nil {
+			onTimeout(expired)
+		}
+		if group != nil || expired != nil {
+			l.notifyPositions()
+		}
+	}
+}
+
+// Remove takes the player out of the lobby, returns false when the player was not waiting
+func (l *GroupLobby) Remove(profileName string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := l.indexOf(profileName)
+	if i < 0 {
+		return false
+	}
+	l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+	return true
+}
+
+// RemoveConnection takes the player out of the lobby only when it is waiting with the given socket
+func (l *GroupLobby) RemoveConnection(profileName string, conn *Connection) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := l.indexOf(profileName)
+	if i < 0 || l.waiting[i].Player.Connection != conn {
+		return false
+	}
+	l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+	return true
+}
+
+// Waiting returns the players in the lobby in joining order
+func (l *GroupLobby) Waiting() []PlayerInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	players := make([]PlayerInfo, len(l.waiting))
+	for i, entry := range l.waiting {
+		players[i] = entry.Player
+	}
+	return players
+}
+
+// Sends the new positions to everyone still waiting in the lobby
+func (l *GroupLobby) notifyPositions() {
+	for i, player := range l.Waiting() {
+		sendQueuePosition(player, i+1)
+	}
+}
+
+// Fills the group with bots until it has count players
+func fillWithBots(players []PlayerInfo, count int) ([]PlayerInfo, error) {
+	for len(players) < count {
+		bot, err := newBotPlayer(config.Bot)
+		if err != nil {
+			return nil, err
+		}
+		players = append(players, bot)
+	}
+	return players, nil
+}
+
+// Tells the players that nobody was found before the queue timeout
+func sendQueueTimeout(players []PlayerInfo) {
+	for _, player := range players {
+		if err := player.send("queue_timeout", nil); err != nil {
+			log.Printf("Error sending queue timeout to %s\n", player.ProfileName)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Matches collection holding a record of every completed match, history entries point to it with the match id
+var matchesCollection *mongo.Collection
+
+// MatchRecord is everything that happened in one match, saved once the match is over
+type MatchRecord struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	RoomId string             `bson:"roomId" json:"roomId"`
+	Mode   string             `bson:"mode" json:"mode"`
+	Ranked bool               `bson:"ranked" json:"ranked"`
+	// Every question asked in the match in order with the time it ran on the server timer
+	Rounds []MatchRound `bson:"rounds" json:"rounds"`
+	// Every player from the first to the last place, players who left a free-for-all match early come last
+	Players []MatchPlayerRecord `bson:"players" json:"players"`
+	// Only set when a single player finished first
+	Winner string `bson:"winner,omitempty" json:"winner,omitempty"`
+	// Only set in team matches, 0 for a draw
+	WinningTeam int       `bson:"winningTeam,omitempty" json:"winningTeam,omitempty"`
+	IsDrawn     bool      `bson:"isDrawn" json:"isDrawn"`
+	ForfeitedBy string    `bson:"forfeitedBy,omitempty" json:"forfeitedBy,omitempty"`
+	StartedAt   time.Time `bson:"startedAt" json:"startedAt"`
+	EndedAt     time.Time `bson:"endedAt" json:"endedAt"`
+}
+
+// MatchRound is one question of the match
+// The options are kept in the order they were shown since they are shuffled for every match and the selected options point into them
+type MatchRound struct {
+	QuestionId    string    `bson:"questionId" json:"questionId"`
+	Category      string    `bson:"category" json:"category"`
+	Question      string    `bson:"question" json:"question"`
+	Options       []string  `bson:"options" json:"options"`
+	CorrectOption int       `bson:"correctOption" json:"correctOption"`
+	StartedAt     time.Time `bson:"startedAt" json:"startedAt"`
+	// Deadline or the time the last player answered, zero when the match ended during the question
+	EndedAt time.Time `bson:"endedAt" json:"endedAt"`
+}
+
+// MatchPlayerRecord is the match of one player
+type MatchPlayerRecord struct {
+	ProfileName string `bson:"profileName" json:"profileName"`
+	Team        int    `bson:"team,omitempty" json:"team,omitempty"`
+	Bot         bool   `bson:"bot,omitempty" json:"bot,omitempty"`
+	Place       int    `bson:"place" json:"place"`
+	// "Won", "Lost", "Draw" or "Forfeit" like in the history
+	Result string `bson:"result" json:"result"`
+	Points uint16 `bson:"points" json:"points"`
+	// Points after every question starting with 0
+	PointsProgression []uint16       `bson:"pointsProgression" json:"pointsProgression"`
+	Answers           []AnswerRecord `bson:"answers" json:"answers"`
+	TrophyDelta       int            `bson:"trophyDelta" json:"trophyDelta"`
+	// Titles of the achievements unlocked in this match
+	Achievements []string `bson:"achievements,omitempty" json:"achievements,omitempty"`
+}
+
+// Indexes used to find the matches of a profile, newest first
+func ensureMatchIndexes(ctx context.Context) {
+	_, err := matchesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "players.profileName", Value: 1}, {Key: "endedAt", Value: -1}},
+	})
+	if err != nil {
+		log.Printf("Error creating match indexes: %v", err)
+	}
+}
+
+// Builds the record of the player for the match record from its standing in the result
+// The caller must hold the room lock
+func matchPlayerRecord(player *PlayerInfo, standing Standing, result MatchResult) MatchPlayerRecord {
+	return MatchPlayerRecord{
+		ProfileName:       player.ProfileName,
+		Team:              player.Team,
+		Bot:               player.Bot != nil,
+		Place:             standing.Place,
+		Result:            result.outcome(standing),
+		Points:            player.PlayerPoints,
+		PointsProgression: pointsProgression(player),
+		Answers:           player.Answers,
+		TrophyDelta:       standing.Trophies,
+		Achievements:      achievementTitles(result, player.ProfileName),
+	}
+}
+
+// Builds the record of the match of the room once its result is known
+// The caller must hold the room lock
+func (room *Room) matchRecord(result MatchResult) MatchRecord {
+	record := MatchRecord{
+		ID:          room.MatchId,
+		RoomId:      room.ID,
+		Mode:        room.Mode,
+		Ranked:      room.Ranked,
+		Rounds:      room.Rounds,
+		Winner:      result.Winner,
+		WinningTeam: result.WinningTeam,
+		IsDrawn:     result.IsDrawn,
+		ForfeitedBy: result.ForfeitedBy,
+		StartedAt:   room.StartedAt,
+		EndedAt:     time.Now(),
+	}
+	for _, standing := range result.Standings {
+		if player := room.player(standing.ProfileName); player != nil {
+			record.Players = append(record.Players, matchPlayerRecord(player, standing, result))
+		}
+	}
+	record.Players = append(record.Players, room.Departed...)
+	return record
+}
+
+// Saves the record of a completed match
+func saveMatchRecord(record MatchRecord) {
+	if _, err := matchesCollection.InsertOne(context.TODO(), record); err != nil {
+		log.Printf("Error saving match record of room %s: %v\n", record.RoomId, err)
+	}
+}
+
+// Titles of the achievements the player unlocked in the match, achievements are only unlocked in ranked matches
+func achievementTitles(result MatchResult, profileName string) []string {
+	var titles []string
+	if !result.Ranked {
+		return titles
+	}
+	if result.PerfectScore[profileName] {
+		titles = append(titles, "Perfect Round")
+	}
+	if result.LightningReflexes[profileName] {
+		titles = append(titles, "Lightning Reflexes")
+	}
+	if result.ClutchPerformer == profileName {
+		titles = append(titles, "Clutch Performer")
+	}
+	return titles
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var errAlreadyQueued = errors.New("player is already in the matchmaking queue")
+
+// Sent to a waiting player with its place in the matchmaking queue (1 is the next player to be matched)
+type QueuePositionMessage struct {
+	Position int `json:"position"`
+}
+
+// A player waiting in the matchmaking queue
+type queueEntry struct {
+	Player   PlayerInfo
+	Rating   int
+	JoinedAt time.Time
+}
+
+// Settings of the skill based matching
+type MatchmakingSettings struct {
+	// Rating difference allowed as soon as a player joins the queue
+	BaseWindow int
+	// The allowed difference grows by WindowGrowth every WindowInterval the player waits
+	WindowGrowth   int
+	WindowInterval time.Duration
+	// After waiting this long the player is matched with anyone
+	MaxWait time.Duration
+	// After waiting this long the player leaves the queue (queue_timeout or a bot opponent)
+	QueueTimeout time.Duration
+}
+
+// MatchmakingQueue holds the players waiting for an opponent, separate from the rooms of the matches in progress
+// Players are matched with the longest waiting player whose rating is within the search window
+type MatchmakingQueue struct {
+	mu       sync.Mutex
+	settings MatchmakingSettings
+	waiting  *list.List
+	// Element of every queued profile so leaving the queue doesn't need to traverse it
+	entries map[string]*list.Element
+}
+
+// Creates an empty matchmaking queue
+func NewMatchmakingQueue(settings MatchmakingSettings) *MatchmakingQueue {
+	return &MatchmakingQueue{
+		settings: settings,
+		waiting:  list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Matchmaking queue used by the websocket handlers, created in main from the config
+var matchmaking *MatchmakingQueue
+
+// Rating difference the entry accepts after waiting until now, -1 means any opponent
+func (q *MatchmakingQueue) searchWindow(entry *queueEntry, now time.Time) int {
+	waited := now.Sub(entry.JoinedAt)
+	if waited >= q.settings.MaxWait {
+		return -1
+	}
+	return q.settings.BaseWindow + q.settings.WindowGrowth*int(waited/q.settings.WindowInterval)
+}
+
+// Checks if two waiting players can be matched, the wider search window of the two decides
+func (q *MatchmakingQueue) canMatch(a *queueEntry, b *queueEntry, now time.Time) bool {
+	windowA, windowB := q.searchWindow(a, now), q.searchWindow(b, now)
+	if windowA < 0 || windowB < 0 {
+		return true
+	}
+	difference := a.Rating - b.Rating
+	if difference < 0 {
+		difference = -difference
+	}
+	return difference <= max(windowA, windowB)
+}
+
+// Enqueue pairs the player with the longest waiting player within the search window
+// When nobody fits the player is added to the back of the queue and its position is returned instead
+func (q *MatchmakingQueue) Enqueue(player PlayerInfo, rating int) (opponent *PlayerInfo, position int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, queued := q.entries[player.ProfileName]; queued {
+		return nil, 0, errAlreadyQueued
+	}
+
+	now := time.Now()
+	newEntry := &queueEntry{Player: player, Rating: rating, JoinedAt: now}
+	// Traverse from the front so the player who waited the longest gets the first chance
+	for element := q.waiting.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*queueEntry)
+		if q.canMatch(entry, newEntry, now) {
+			q.waiting.Remove(element)
+			delete(q.entries, entry.Player.ProfileName)
+			return &entry.Player, 0, nil
+		}
+	}
+
+	q.entries[player.ProfileName] = q.waiting.PushBack(newEntry)
+	return nil, q.waiting.Len(), nil
+}
+
+// MatchWaiting pairs the waiting players whose search windows grew enough while they were waiting
+func (q *MatchmakingQueue) MatchWaiting() [][2]PlayerInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var pairs [][2]PlayerInfo
+	for element := q.waiting.Front(); element != nil; {
+		entry := element.Value.(*queueEntry)
+		var match *list.Element
+		for other := element.Next(); other != nil; other = other.Next() {
+			if q.canMatch(entry, other.Value.(*queueEntry), now) {
+				match = other
+				break
+			}
+		}
+		next := element.Next()
+		if match == nil {
+			element = next
+			continue
+		}
+		// The next element can be the match itself so move past it before removing it
+		if next == match {
+			next = match.Next()
+		}
+		opponent := match.Value.(*queueEntry)
+		q.waiting.Remove(element)
+		q.waiting.Remove(match)
+		delete(q.entries, entry.Player.ProfileName)
+		delete(q.entries, opponent.Player.ProfileName)
+		pairs = append(pairs, [2]PlayerInfo{entry.Player, opponent.Player})
+		element = next
+	}
+	return pairs
+}
+
+// Expire removes the players who waited longer than the queue timeout
+func (q *MatchmakingQueue) Expire() []PlayerInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var expired []PlayerInfo
+	// The queue is in joining order so every expired player is at the front
+	for element := q.waiting.Front(); element != nil; element = q.waiting.Front() {
+		entry := element.Value.(*queueEntry)
+		if now.Sub(entry.JoinedAt) < q.settings.QueueTimeout {
+			break
+		}
+		q.waiting.Remove(element)
+		delete(q.entries, entry.Player.ProfileName)
+		expired = append(expired, entry.Player)
+	}
+	return expired
+}
+
+// Widens the search windows every second, starts the matches of the players who can now be paired and removes the players who waited too long
+func (q *MatchmakingQueue) Run(onMatch func(a PlayerInfo, b PlayerInfo), onTimeout func(player PlayerInfo)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		pairs := q.MatchWaiting()
+		for _, pair := range pairs {
+			onMatch(pair[0], pair[1])
+		}
+		expired := q.Expire()
+		for _, player := range expired {
+			onTimeout(player)
+		}
+		if len(pairs) > 0 || len(expired) > 0 {
+			notifyQueuePositions()
+		}
+	}
+}
+
+// Remove takes the player out of the queue, returns false when the player was not waiting
+func (q *MatchmakingQueue) Remove(profileName string) (PlayerInfo, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	element, queued := q.entries[profileName]
+	if !queued {
+		return PlayerInfo{}, false
+	}
+	entry := q.waiting.Remove(element).(*queueEntry)
+	delete(q.entries, profileName)
+	return entry.Player, true
+}
+
+// RemoveConnection takes the player out of the queue only when it is waiting with the given socket
+// Another socket of the same profile (a second tab) keeps its place in the queue
+func (q *MatchmakingQueue) RemoveConnection(profileName string, conn *Connection) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	element, queued := q.entries[profileName]
+	if !queued || element.Value.(*queueEntry).Player.Connection != conn {
+		return false
+	}
+	q.waiting.Remove(element)
+	delete(q.entries, profileName)
+	return true
+}
+
+// Waiting returns the queued players in queue order
+func (q *MatchmakingQueue) Waiting() []PlayerInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	players := make([]PlayerInfo, 0, q.waiting.Len())
+	for element := q.waiting.Front(); element != nil; element = element.Next() {
+		players = append(players, element.Value.(*queueEntry).Player)
+	}
+	return players
+}
+
+// Sends the position in the queue to the player
+func sendQueuePosition(player PlayerInfo, position int) {
+	if err := player.send("queue_position", QueuePositionMessage{Position: position}); err != nil {
+		log.Printf("Error sending queue position to %s\n", player.ProfileName)
+	}
+}
+
+// Sends the new positions to everyone still waiting after a player left the queue
+func notifyQueuePositions() {
+	for i, player := range matchmaking.Waiting() {
+		sendQueuePosition(player, i+1)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes the password with bcrypt (the salt is stored inside the hash)
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Checks if the stored password is a bcrypt hash or a legacy plaintext password saved before passwords were hashed
+func isPasswordHashed(storedPassword string) bool {
+	return strings.HasPrefix(storedPassword, "$2a$") || strings.HasPrefix(storedPassword, "$2b$") || strings.HasPrefix(storedPassword, "$2y$")
+}
+
+// Compares the password sent by the user with the stored password
+// needsUpgrade is true when the stored password is legacy plaintext and should be replaced with a hash
+func checkPassword(storedPassword string, password string) (matches bool, needsUpgrade bool) {
+	if isPasswordHashed(storedPassword) {
+		return bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)) == nil, false
+	}
+	// Legacy plaintext record, compare in constant time so the time taken doesn't leak how many characters matched
+	matches = subtle.ConstantTimeCompare([]byte(storedPassword), []byte(password)) == 1
+	return matches, matches
+}
+
+// Replaces the legacy plaintext password of the profile with a bcrypt hash
+// Filtering on the old password makes sure a password changed in the meantime is never overwritten
+func upgradeLegacyPassword(profileName string, plaintextPassword string) {
+	hash, err := hashPassword(plaintextPassword)
+	if err != nil {
+		log.Printf("Error hashing legacy password of %s: %v", profileName, err)
+		return
+	}
+	filter := bson.M{"profileName": profileName, "profilePassword": plaintextPassword}
+	update := bson.M{"$set": bson.M{"profilePassword": hash}}
+	if _, err := collection.UpdateOne(context.TODO(), filter, update); err != nil {
+		log.Printf("Error upgrading legacy password of %s: %v", profileName, err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+)
+
+// Invite codes use capital letters and digits without the look-alikes (0/O, 1/I/L) so they are easy to read out to a friend
+const (
+	inviteCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	inviteCodeLength   = 6
+)
+
+var (
+	errInvalidInviteCode  = errors.New("invite code does not exist")
+	errPrivateRoomFull    = errors.New("private room is already full")
+	errInvalidRoomSize    = errors.New("private rooms are for 2 to 8 players")
+	errNotEnoughPlayers   = errors.New("private room needs at least 2 players to start")
+	errPrivateRoomStarted = errors.New("private room already started")
+)
+
+// Sent to the host once the private room is created
+type PrivateRoomCreatedMessage struct {
+	RoomId     string `json:"roomId"`
+	InviteCode string `json:"inviteCode"`
+	Ranked     bool   `json:"ranked"`
+	MaxPlayers int    `json:"maxPlayers"`
+}
+
+// Sent to everyone in a private room when a player joins and the room is not full yet
+type PrivateRoomJoinedMessage struct {
+	RoomId     string   `json:"roomId"`
+	Players    []string `json:"players"`
+	MaxPlayers int      `json:"maxPlayers"`
+}
+
+// Creates a random invite code from the invite code alphabet
+func generateInviteCode() (string, error) {
+	var code strings.Builder
+	alphabetSize := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for i := 0; i < inviteCodeLength; i++ {
+		index, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(inviteCodeAlphabet[index.Int64()])
+	}
+	return code.String(), nil
+}
+
+// Codes are shown in capitals but a friend may type them in lowercase
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CreatePrivate creates a room which only players with the invite code can join
+// Private rooms are unranked unless the host opts in, rooms for more than 2 players are played free-for-all unless they are team rooms
+func (m *RoomManager) CreatePrivate(ranked bool, mode string, maxPlayers int, host PlayerInfo) (*Room, error) {
+	// Older clients don't send the room size and always play a duel
+	if maxPlayers == 0 {
+		maxPlayers = minRoomPlayers
+	}
+	if mode == modeTeams {
+		maxPlayers = teamRoomPlayers
+	}
+	if maxPlayers < minRoomPlayers || maxPlayers > maxRoomPlayers {
+		return nil, errInvalidRoomSize
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, inRoom := m.profileRooms[host.ProfileName]; inRoom {
+		return nil, errAlreadyInRoom
+	}
+
+	roomId, err := generateRandomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	// Keep generating until the code is not used by another waiting private room
+	var inviteCode string
+	for {
+		inviteCode, err = generateInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, used := m.inviteCodes[inviteCode]; !used {
+			break
+		}
+	}
+
+	room := newRoom(roomId, host)
+	room.Ranked = ranked
+	room.InviteCode = inviteCode
+	room.MaxPlayers = maxPlayers
+	if mode == modeTeams {
+		room.Mode = modeTeams
+	} else if maxPlayers > minRoomPlayers {
+		room.Mode = modeFreeForAll
+	}
+	m.rooms[roomId] = room
+	m.profileRooms[host.ProfileName] = roomId
+	m.inviteCodes[inviteCode] = roomId
+	return room, nil
+}
+
+// JoinPrivate adds the player to the private room with the invite code
+// The code stops working once the room is full so nobody joins after the match started
+// Returns true when the room is now full and the match can start
+func (m *RoomManager) JoinPrivate(inviteCode string, player PlayerInfo) (*Room, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, inRoom := m.profileRooms[player.ProfileName]; inRoom {
+		return nil, false, errAlreadyInRoom
+	}
+	roomId, exists := m.inviteCodes[normalizeInviteCode(inviteCode)]
+	if !exists {
+		return nil, false, errInvalidInviteCode
+	}
+	room := m.rooms[roomId]
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.Players) >= room.MaxPlayers || room.Closed {
+		return nil, false, errPrivateRoomFull
+	}
+	room.Players = append(room.Players, player)
+	m.profileRooms[player.ProfileName] = roomId
+	full := len(room.Players) == room.MaxPlayers
+	if full {
+		delete(m.inviteCodes, room.InviteCode)
+	}
+	return room, full, nil
+}
+
+// IsRankedPrivate reports whether the private room with the invite code changes trophies and ratings, false when the code is not valid
+func (m *RoomManager) IsRankedPrivate(inviteCode string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roomId, exists := m.inviteCodes[normalizeInviteCode(inviteCode)]
+	if !exists {
+		return false
+	}
+	room := m.rooms[roomId]
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.Ranked
+}
+
+// StartPrivate closes the private room of the player to new players so the match can start before the room is full
+func (m *RoomManager) StartPrivate(profileName string) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roomId, inRoom := m.profileRooms[profileName]
+	if !inRoom {
+		return nil, errPlayerNotInRoom
+	}
+	room := m.rooms[roomId]
+	if m.inviteCodes[room.InviteCode] != roomId {
+		return nil, errPrivateRoomStarted
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	// Team rooms start once both teams are complete
+	if len(room.Players) < minRoomPlayers || (room.Mode == modeTeams && len(room.Players) != teamRoomPlayers) {
+		return nil, errNotEnoughPlayers
+	}
+	delete(m.inviteCodes, room.InviteCode)
+	// The mode follows the players who actually joined
+	if len(room.Players) == minRoomPlayers {
+		room.Mode = modeDuel
+	}
+	return room, nil
+}
+
+// Tells everyone in a private room who joined so far
+func announcePrivateRoomPlayers(room *Room) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	joinedMessage := PrivateRoomJoinedMessage{
+		RoomId:     room.ID,
+		MaxPlayers: room.MaxPlayers,
+	}
+	for _, player := range room.Players {
+		joinedMessage.Players = append(joinedMessage.Players, player.ProfileName)
+	}
+	for _, player := range room.Players {
+		if err := player.send("private_room_joined", joinedMessage); err != nil {
+			log.Printf("Error sending private room players to %s\n", player.ProfileName)
+		}
+	}
+}
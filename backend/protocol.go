@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Version of the websocket protocol, every envelope carries it and clients speaking another version are rejected
+const protocolVersion = 1
+
+// Codes of the error replies, clients switch on the code and show the message
+const (
+	errorCodeMalformedMessage   = "malformed_message"
+	errorCodeUnsupportedVersion = "unsupported_version"
+	errorCodeUnknownType        = "unknown_type"
+	errorCodeMalformedPayload   = "malformed_payload"
+	errorCodeInternal           = "internal_error"
+)
+
+// Envelope wraps every websocket message in both directions
+// Type is the action of the client or the event of the server and decides what the payload holds
+// Seq numbers the messages of one side of the connection, error replies point to the seq of the client message they answer
+type Envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Payload of the error event sent when a client message is unknown, malformed or rejected
+type ErrorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Seq of the client message the error answers, 0 when the envelope could not be read
+	ReplyTo uint64 `json:"replyTo,omitempty"`
+}
+
+// ActionError is an error of an action with the code sent to the client
+type ActionError struct {
+	Code string
+	Err  error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// Codes of the errors returned by the actions, errors without a code are internal errors and their details are only logged
+var actionErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{errAlreadyInRoom, "already_in_room"},
+	{errAlreadyQueued, "already_queued"},
+	{errRoomNotFound, "room_not_found"},
+	{errPlayerNotInRoom, "not_in_room"},
+	{errInvalidInviteCode, "invalid_invite_code"},
+	{errPrivateRoomFull, "private_room_full"},
+	{errInvalidRoomSize, "invalid_room_size"},
+	{errNotEnoughPlayers, "not_enough_players"},
+	{errPrivateRoomStarted, "private_room_started"},
+	{errMatchNotFinished, "match_not_finished"},
+	{errNoRematchRequested, "no_rematch_requested"},
+	{errInvalidResumeToken, "invalid_resume_token"},
+	{errSpectatingOwnRoom, "spectating_own_room"},
+	{errTooManySpectators, "too_many_spectators"},
+	{errNotTeamRoom, "not_team_room"},
+	{errEmptyTeamChat, "empty_team_chat"},
+	{errAlreadyAnswered, "already_answered"},
+	{errWrongQuestion, "wrong_question"},
+	{errDeadlinePassed, "deadline_passed"},
+	{errInvalidOption, "invalid_option"},
+	{errMatchAlreadyScored, "match_already_scored"},
+	{errMatchNotFound, "match_not_found"},
+	{errInvalidReplaySpeed, "invalid_replay_speed"},
+}
+
+// Returns the code the client gets for the error of an action, false for internal errors
+func actionErrorCode(err error) (string, bool) {
+	var actionErr *ActionError
+	if errors.As(err, &actionErr) {
+		return actionErr.Code, true
+	}
+	for _, known := range actionErrorCodes {
+		if errors.Is(err, known.err) {
+			return known.code, true
+		}
+	}
+	return "", false
+}
+
+// Client is the profile behind a websocket connection, the profile name always comes from the verified token
+type Client struct {
+	Connection  *Connection
+	ProfileName string
+}
+
+// Returns the player used to queue the client or to seat it in a room
+func (c *Client) player() PlayerInfo {
+	return PlayerInfo{Connection: c.Connection, ProfileName: c.ProfileName}
+}
+
+// Sends an error event answering the client message with the seq
+func (c *Client) replyError(replyTo uint64, code string, message string) {
+	if err := c.Connection.Send("error", ErrorMessage{Code: code, Message: message, ReplyTo: replyTo}); err != nil {
+		log.Printf("Error sending %s error to %s\n", code, c.ProfileName)
+	}
+}
+
+// Action is a message of the client, decoded from the payload of its envelope
+type Action interface {
+	handle(client *Client) error
+}
+
+// Decodes the envelope of a client message, runs its action and replies with an error event when it can't be run
+func dispatch(client *Client, message []byte) {
+	var envelope Envelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		client.replyError(0, errorCodeMalformedMessage, "message is not a valid envelope")
+		return
+	}
+	if envelope.Version != protocolVersion {
+		client.replyError(envelope.Seq, errorCodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported, use version %d", envelope.Version, protocolVersion))
+		return
+	}
+	newAction, known := actions[envelope.Type]
+	if !known {
+		client.replyError(envelope.Seq, errorCodeUnknownType, fmt.Sprintf("unknown message type %q", envelope.Type))
+		return
+	}
+	action := newAction()
+	// Actions without fields may leave the payload out
+	if len(envelope.Payload) > 0 {
+		if err := json.Unmarshal(envelope.Payload, action); err != nil {
+			client.replyError(envelope.Seq, errorCodeMalformedPayload, fmt.Sprintf("invalid %s payload: %v", envelope.Type, err))
+			return
+		}
+	}
+
+	if err := action.handle(client); err != nil {
+		code, known := actionErrorCode(err)
+		if !known {
+			log.Printf("Error handling %s from %s: %v\n", envelope.Type, client.ProfileName, err)
+			client.replyError(envelope.Seq, errorCodeInternal, fmt.Sprintf("%s could not be completed", envelope.Type))
+			return
+		}
+		log.Printf("Rejected %s from %s: %v\n", envelope.Type, client.ProfileName, err)
+		message := err.Error()
+		var actionErr *ActionError
+		if errors.As(err, &actionErr) {
+			message = actionErr.Err.Error()
+		}
+		client.replyError(envelope.Seq, code, message)
+	}
+}
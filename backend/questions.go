@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Number of questions drawn for every match
+const questionsPerMatch = 5
+
+// Question struct to hold a single multiple choice question from the question bank
+type Question struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Category   string             `bson:"category" json:"category"`
+	Difficulty string             `bson:"difficulty" json:"difficulty"`
+	Question   string             `bson:"question" json:"question"`
+	Options    []string           `bson:"options" json:"options"`
+	// Index of the correct option inside Options
+	// ! Never send this to the players (json:"-") otherwise anyone can read the answer from the devtools
+	CorrectOption int `bson:"correctOption" json:"-"`
+}
+
+// QuestionStore is the source of questions for the rooms
+// Anything that can hand out a random set of questions (MongoDB, in-memory list for local testing) can be used as a question store
+type QuestionStore interface {
+	RandomQuestions(ctx context.Context, count int) ([]Question, error)
+}
+
+// mongoQuestionStore draws questions from the questions collection
+type mongoQuestionStore struct {
+	collection *mongo.Collection
+}
+
+// Question store used by the websocket handlers
+var questionStore QuestionStore
+
+// RandomQuestions picks count random questions using the $sample aggregation stage so the whole collection is never loaded in-memory
+func (s *mongoQuestionStore) RandomQuestions(ctx context.Context, count int) ([]Question, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$sample", Value: bson.M{"size": count}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var questions []Question
+	if err := cursor.All(ctx, &questions); err != nil {
+		return nil, err
+	}
+	// $sample returns fewer documents when the collection is smaller than the sample size
+	if len(questions) < count {
+		return nil, fmt.Errorf("question bank has %d questions, need %d", len(questions), count)
+	}
+
+	// Shuffle the options of every question so the correct answer is not always at the same position
+	for i := range questions {
+		shuffleOptions(&questions[i])
+	}
+	return questions, nil
+}
+
+// Shuffles the options of a question in place and keeps CorrectOption pointing at the correct answer
+func shuffleOptions(question *Question) {
+	rand.Shuffle(len(question.Options), func(i, j int) {
+		question.Options[i], question.Options[j] = question.Options[j], question.Options[i]
+		if question.CorrectOption == i {
+			question.CorrectOption = j
+		} else if question.CorrectOption == j {
+			question.CorrectOption = i
+		}
+	})
+}
+
+// Default questions inserted when the questions collection is empty (same questions that were hardcoded in Room.jsx)
+var defaultQuestions = []Question{
+	{
+		Category:      "Sports",
+		Difficulty:    "easy",
+		Question:      "In baseball, how many fouls are an out?",
+		Options:       []string{"0", "5", "3", "2"},
+		CorrectOption: 0,
+	},
+	{
+		Category:      "Sports",
+		Difficulty:    "medium",
+		Question:      "Which NBA player won Most Valuable Player for the 1999-2000 season?",
+		Options:       []string{"Shaquille O'Neal", "Allen Iverson", "Kobe Bryant", "Paul Pierce"},
+		CorrectOption: 0,
+	},
+	{
+		Category:      "Sports",
+		Difficulty:    "easy",
+		Question:      "What team won the 2016 MLS Cup?",
+		Options:       []string{"Seattle Sounders", "Colorado Rapids", "Toronto FC", "Montreal Impact"},
+		CorrectOption: 0,
+	},
+	{
+		Category:      "Sports",
+		Difficulty:    "medium",
+		Question:      "What is the exact length of one non-curved part in Lane 1 of an Olympic Track?",
+		Options:       []string{"84.39m", "100m", "100yd", "109.36yd"},
+		CorrectOption: 0,
+	},
+	{
+		Category:      "Sports",
+		Difficulty:    "medium",
+		Question:      "Which of the following player scored a hat-trick during their Manchester United debut?",
+		Options:       []string{"Wayne Rooney", "Cristiano Ronaldo", "Robin Van Persie", "David Beckham"},
+		CorrectOption: 0,
+	},
+}
+
+// Inserts the default questions if the questions collection is empty so a fresh database can still start matches
+func seedQuestions(ctx context.Context, questionsCollection *mongo.Collection) {
+	count, err := questionsCollection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		log.Printf("Error counting questions: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	documents := make([]interface{}, len(defaultQuestions))
+	for i, question := range defaultQuestions {
+		documents[i] = question
+	}
+	if _, err := questionsCollection.InsertMany(ctx, documents); err != nil {
+		log.Printf("Error seeding questions: %v", err)
+		return
+	}
+	fmt.Printf("Seeded %d default questions\n", len(documents))
+}
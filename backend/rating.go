@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Rating of a new profile (and of profiles created before ratings existed)
+	defaultRating = 1200
+	// Maximum rating change of a single match
+	eloKFactor = 32
+)
+
+// Probability of a player with rating a beating a player with rating b
+func expectedScore(a int, b int) float64 {
+	return 1 / (1 + math.Pow(10, float64(b-a)/400))
+}
+
+// Rating changes of every player from the final standings (ratings and places in the same order)
+// Every pair of players is scored like a duel (the better place wins, the same place is a draw) and the sum is divided by the number of opponents so a free-for-all match moves a rating about as much as a duel
+func placementRatingDeltas(ratings []int, places []int) []int {
+	sums := make([]float64, len(ratings))
+	for i := range ratings {
+		for j := i + 1; j < len(ratings); j++ {
+			score := 0.5
+			if places[i] < places[j] {
+				score = 1
+			} else if places[i] > places[j] {
+				score = 0
+			}
+			// Elo is zero sum so the opponent loses exactly what the player gains
+			change := eloKFactor * (score - expectedScore(ratings[i], ratings[j]))
+			sums[i] += change
+			sums[j] -= change
+		}
+	}
+	deltas := make([]int, len(ratings))
+	if len(ratings) < 2 {
+		return deltas
+	}
+	for i := range sums {
+		deltas[i] = int(math.Round(sums[i] / float64(len(ratings)-1)))
+	}
+	return deltas
+}
+
+// Gets the rating of the profile used for skill based matching
+func getRating(ctx context.Context, profileName string) (int, error) {
+	var profile struct {
+		Rating *int `bson:"rating"`
+	}
+	err := collection.FindOne(ctx, bson.M{"profileName": profileName}, options.FindOne().SetProjection(bson.M{"rating": 1, "_id": 0})).Decode(&profile)
+	if err != nil {
+		return 0, err
+	}
+	// Profiles created before ratings existed start from the default rating
+	if profile.Rating == nil {
+		return defaultRating, nil
+	}
+	return *profile.Rating, nil
+}
+
+// Standings the ratings are computed from, the players who left a free-for-all match early come after everyone who finished
+// The last player to leave is ahead of the ones who left before it
+func ratingStandings(result MatchResult) []Standing {
+	standings := append([]Standing(nil), result.Standings...)
+	for i := len(result.Departed) - 1; i >= 0; i-- {
+		standings = append(standings, Standing{ProfileName: result.Departed[i], Place: len(standings) + 1})
+	}
+	return standings
+}
+
+// Set at startup, transactions need a replica set or a sharded cluster (Atlas always runs one, a standalone mongod does not)
+var transactionsSupported bool
+
+// Checks if the deployment the client is connected to supports transactions
+func supportsTransactions(ctx context.Context) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("Error checking transaction support: %v", err)
+		return false
+	}
+	// Members of a replica set report its name, mongos reports isdbgrid
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// Reads the ratings of the players in the standings and computes their rating changes
+func ratingDeltas(ctx context.Context, result MatchResult, standings []Standing) ([]int, []int, error) {
+	ratings := make([]int, len(standings))
+	places := make([]int, len(standings))
+	for i, standing := range standings {
+		rating, err := getRating(ctx, standing.ProfileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		ratings[i] = rating
+		places[i] = standing.Place
+	}
+
+	if result.Mode == modeTeams {
+		teams := make([]int, len(standings))
+		for i, standing := range standings {
+			teams[i] = standing.Team
+		}
+		return ratings, teamRatingDeltas(ratings, teams, result.WinningTeam), nil
+	}
+	return ratings, placementRatingDeltas(ratings, places), nil
+}
+
+// Updates the ratings of every player in the standings (and of the players who left early) from their ratings before the match
+// Reading and writing all ratings inside one transaction makes the update atomic even if a player finishes another match at the same time
+// Without transactions every rating is incremented by its change instead so a concurrent update of the same player is never overwritten
+func updateRatings(result MatchResult) {
+	standings := ratingStandings(result)
+	if !transactionsSupported {
+		if err := incrementRatings(context.TODO(), result, standings); err != nil {
+			log.Printf("Error updating ratings: %v", err)
+		}
+		return
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		log.Printf("Error starting rating session: %v", err)
+		return
+	}
+	defer session.EndSession(context.TODO())
+
+	_, err = session.WithTransaction(context.TODO(), func(ctx mongo.SessionContext) (interface{}, error) {
+		ratings, deltas, err := ratingDeltas(ctx, result, standings)
+		if err != nil {
+			return nil, err
+		}
+		for i, standing := range standings {
+			if _, err := collection.UpdateOne(ctx, bson.M{"profileName": standing.ProfileName}, bson.M{"$set": bson.M{"rating": ratings[i] + deltas[i]}}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		log.Printf("Error updating ratings: %v", err)
+	}
+}
+
+// Adds the rating change of every player to its current rating, used when the deployment has no transactions
+// The changes are computed from the ratings read in the same call, profiles without a rating start from the default rating
+func incrementRatings(ctx context.Context, result MatchResult, standings []Standing) error {
+	_, deltas, err := ratingDeltas(ctx, result, standings)
+	if err != nil {
+		return err
+	}
+	for i, standing := range standings {
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"rating": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating", defaultRating}}, deltas[i]}},
+		}}}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"profileName": standing.ProfileName}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For getting the leaderboard sorted by rating (trophies stay the visible reward on the normal leaderboard)
+func getRatingLeaderboardData(w http.ResponseWriter, r *http.Request) {
+	opts := options.Find().SetSort(bson.M{"rating": -1}).SetProjection(bson.M{"profileName": 1, "trophies": 1, "rating": 1}).SetLimit(10)
+	// Profiles without a rating haven't played since ratings were added so leave them out
+	cursor, err := collection.Find(context.TODO(), bson.M{"rating": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		http.Error(w, "Failed to retrieve leaderboard data", http.StatusInternalServerError)
+		return
+	}
+
+	var leaderboard []Profile
+	if err := cursor.All(context.TODO(), &leaderboard); err != nil {
+		http.Error(w, "Failed to decode leaderboard data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(leaderboard); err != nil {
+		http.Error(w, "Failed to encode leaderboard data", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestPlacementRatingDeltas(t *testing.T) {
+	tests := []struct {
+		name       string
+		ratings    []int
+		places     []int
+		wantDeltas []int
+	}{
+		{name: "duel between equal ratings", ratings: []int{1200, 1200}, places: []int{1, 2}, wantDeltas: []int{16, -16}},
+		{name: "favourite wins a duel", ratings: []int{1400, 1200}, places: []int{1, 2}, wantDeltas: []int{8, -8}},
+		{name: "underdog wins a duel", ratings: []int{1400, 1200}, places: []int{2, 1}, wantDeltas: []int{-24, 24}},
+		// A tie scores 0.5 so only the difference between the ratings moves them
+		{name: "tie between equal ratings", ratings: []int{1200, 1200}, places: []int{1, 1}, wantDeltas: []int{0, 0}},
+		{name: "tie between different ratings", ratings: []int{1400, 1200}, places: []int{1, 1}, wantDeltas: []int{-8, 8}},
+		// Every pair is scored like a duel (+16/-16) and the sum is divided by the two opponents
+		{name: "free-for-all averaged over the opponents", ratings: []int{1200, 1200, 1200}, places: []int{1, 2, 3}, wantDeltas: []int{16, 0, -16}},
+		{name: "free-for-all with a tied first place", ratings: []int{1200, 1200, 1200}, places: []int{1, 1, 3}, wantDeltas: []int{8, 8, -16}},
+		{name: "single player", ratings: []int{1200}, places: []int{1}, wantDeltas: []int{0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deltas := placementRatingDeltas(test.ratings, test.places)
+			for i := range deltas {
+				if deltas[i] != test.wantDeltas[i] {
+					t.Errorf("player %d: expected %d, got %d", i, test.wantDeltas[i], deltas[i])
+				}
+			}
+		})
+	}
+}
+
+// Elo is zero sum so whatever one player of a duel gains the other loses
+func TestPlacementRatingDeltasDuelIsZeroSum(t *testing.T) {
+	for _, ratings := range [][]int{{1200, 1200}, {1500, 1100}, {987, 1333}, {2400, 800}} {
+		for _, places := range [][]int{{1, 2}, {2, 1}, {1, 1}} {
+			deltas := placementRatingDeltas(ratings, places)
+			if deltas[0]+deltas[1] != 0 {
+				t.Errorf("ratings %v places %v: deltas %v don't add up to 0", ratings, places, deltas)
+			}
+		}
+	}
+}
+
+func TestTeamRatingDeltas(t *testing.T) {
+	tests := []struct {
+		name        string
+		ratings     []int
+		winningTeam int
+		wantDeltas  []int
+	}{
+		{name: "equal teams", ratings: []int{1200, 1200, 1200, 1200}, winningTeam: 1, wantDeltas: []int{16, 16, -16, -16}},
+		{name: "draw between equal teams", ratings: []int{1200, 1200, 1200, 1200}, winningTeam: 0, wantDeltas: []int{0, 0, 0, 0}},
+		// Team 1 averages 1400 against 1200 so every member moves like the players of a duel between those ratings
+		{name: "teams play with their average rating", ratings: []int{1600, 1200, 1000, 1400}, winningTeam: 2, wantDeltas: []int{-24, -24, 24, 24}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deltas := teamRatingDeltas(test.ratings, []int{1, 1, 2, 2}, test.winningTeam)
+			for i := range deltas {
+				if deltas[i] != test.wantDeltas[i] {
+					t.Errorf("player %d: expected %d, got %d", i, test.wantDeltas[i], deltas[i])
+				}
+			}
+		})
+	}
+}
+
+// Players who left a free-for-all match early rank below everyone who finished, the last to leave ahead of the ones before it
+func TestRatingStandingsRankDepartedLast(t *testing.T) {
+	result := MatchResult{
+		Mode: modeFreeForAll,
+		Standings: []Standing{
+			{ProfileName: "a", Place: 1},
+			{ProfileName: "b", Place: 1},
+			{ProfileName: "c", Place: 3},
+		},
+		// d left first, e after it
+		Departed: []string{"d", "e"},
+	}
+	standings := ratingStandings(result)
+	wantOrder := []string{"a", "b", "c", "e", "d"}
+	wantPlaces := []int{1, 1, 3, 4, 5}
+	if len(standings) != len(wantOrder) {
+		t.Fatalf("expected %d standings, got %d", len(wantOrder), len(standings))
+	}
+	for i, standing := range standings {
+		if standing.ProfileName != wantOrder[i] || standing.Place != wantPlaces[i] {
+			t.Errorf("standing %d: expected %s in place %d, got %s in place %d", i, wantOrder[i], wantPlaces[i], standing.ProfileName, standing.Place)
+		}
+	}
+	if len(result.Standings) != 3 {
+		t.Errorf("the standings of the result were changed")
+	}
+
+	ratings := make([]int, len(standings))
+	places := make([]int, len(standings))
+	for i, standing := range standings {
+		ratings[i] = defaultRating
+		places[i] = standing.Place
+	}
+	deltas := placementRatingDeltas(ratings, places)
+	if deltas[3] >= deltas[2] || deltas[4] >= deltas[3] {
+		t.Errorf("expected the departed players to lose more than the last finisher, got %v", deltas)
+	}
+}
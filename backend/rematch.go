@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// How long a finished room stays open for a rematch before it is removed
+const rematchWindow = 30 * time.Second
+
+var (
+	errMatchNotFinished   = errors.New("match is not finished")
+	errNoRematchRequested = errors.New("opponent did not request a rematch")
+)
+
+// Sent to the other players for rematch_request and rematch_decline
+type RematchMessage struct {
+	From string `json:"from"`
+}
+
+// Keeps the finished room open for a rematch and removes it when nobody asked for one within the rematch window
+func scheduleRoomExpiry(room *Room) {
+	room.mu.Lock()
+	matchNumber := room.MatchNumber
+	room.mu.Unlock()
+
+	time.AfterFunc(rematchWindow, func() {
+		room.mu.Lock()
+		// A rematch started in the meantime (new match number) so the room is still in use
+		expired := room.Completed && room.MatchNumber == matchNumber
+		room.mu.Unlock()
+		if expired {
+			rooms.Complete(room.ID)
+		}
+	})
+}
+
+// Records the rematch request of the player and tells the other players
+// Once every player asked for a rematch (bots always want to play again) the rematch starts right away
+func requestRematch(room *Room, profileName string) error {
+	room.mu.Lock()
+	if !room.Completed || room.Closed || room.player(profileName) == nil {
+		room.mu.Unlock()
+		return errMatchNotFinished
+	}
+	room.RematchRequests[profileName] = true
+
+	everyoneAsked := true
+	for _, player := range room.Players {
+		if player.ProfileName == profileName || player.Bot != nil {
+			continue
+		}
+		if !room.RematchRequests[player.ProfileName] {
+			everyoneAsked = false
+		}
+		if err := player.send("rematch_request", RematchMessage{From: profileName}); err != nil {
+			log.Printf("Error sending rematch request to %s\n", player.ProfileName)
+		}
+	}
+	room.mu.Unlock()
+
+	if everyoneAsked {
+		startRematch(room)
+	}
+	return nil
+}
+
+// Accepts the rematch another player asked for, works like asking for the rematch as well
+func acceptRematch(room *Room, profileName string) error {
+	room.mu.Lock()
+	requested := false
+	for requestedBy := range room.RematchRequests {
+		if requestedBy != profileName {
+			requested = true
+		}
+	}
+	room.mu.Unlock()
+	if !requested {
+		return errNoRematchRequested
+	}
+	return requestRematch(room, profileName)
+}
+
+// Tells the other players that the rematch was declined and closes the room
+func declineRematch(room *Room, profileName string) {
+	room.mu.Lock()
+	if !room.Completed || room.player(profileName) == nil {
+		room.mu.Unlock()
+		return
+	}
+	for _, player := range room.Players {
+		if player.ProfileName == profileName {
+			continue
+		}
+		if err := player.send("rematch_declined", RematchMessage{From: profileName}); err != nil {
+			log.Printf("Error sending rematch decline to %s\n", player.ProfileName)
+		}
+	}
+	room.Closed = true
+	room.mu.Unlock()
+	rooms.Complete(room.ID)
+}
+
+// Resets the finished room and starts a new match with the same players and a new question set
+func startRematch(room *Room) {
+	room.mu.Lock()
+	if !room.Completed || room.Closed {
+		room.mu.Unlock()
+		return
+	}
+	room.resetForRematch()
+	room.mu.Unlock()
+
+	startMatch(room)
+}
+
+// Clears everything of the previous match so the room can be played again
+// The caller must hold the room lock
+func (room *Room) resetForRematch() {
+	for i := range room.Players {
+		room.Players[i].PlayerPoints = 0
+		room.Players[i].Answers = nil
+	}
+	room.Questions = nil
+	room.Rounds = nil
+	room.Departed = nil
+	room.CurrentQuestion = -1
+	room.Completed = false
+	room.RematchRequests = make(map[string]bool)
+	// Invalidates the expiry timer of the previous match
+	room.MatchNumber++
+	select {
+	case <-room.answered:
+	default:
+	}
+}
+
+// Leaves the room of the player if its match is finished so the player can join another match
+// Returns false when the player is still playing a match
+func leaveFinishedRoom(profileName string) bool {
+	room, inRoom := rooms.RoomOf(profileName)
+	if !inRoom {
+		return true
+	}
+	room.mu.Lock()
+	finished := room.Completed
+	room.mu.Unlock()
+	if !finished {
+		return false
+	}
+	declineRematch(room, profileName)
+	return true
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Fastest a replay can be played back
+const maxReplaySpeed = 16
+
+var (
+	errMatchNotFound      = errors.New("match does not exist")
+	errInvalidReplaySpeed = errors.New("replay speed must be above 0 and at most 16")
+)
+
+// Sent when a replay starts with the players of the match, the events follow with their original timing
+// Only the names are sent so the replay does not give the result away
+type ReplayStartMessage struct {
+	MatchId string   `json:"matchId"`
+	Mode    string   `json:"mode"`
+	Players []string `json:"players"`
+	// Number of questions asked in the match
+	QuestionCount int     `json:"questionCount"`
+	Speed         float64 `json:"speed"`
+}
+
+// Sent once every event of the replay was sent
+type ReplayEndMessage struct {
+	MatchId string `json:"matchId"`
+}
+
+// Sent for every event of the match being replayed
+// Event is the type the live match used (question_start, player_answered, question_end, match_result) and Data its payload
+type ReplayEventMessage struct {
+	MatchId string `json:"matchId"`
+	Event   string `json:"event"`
+	// Milliseconds since the match was found, in match time so it does not depend on the speed
+	At   int64       `json:"at"`
+	Data interface{} `json:"data"`
+}
+
+// Answer of a player in a replay, the live match only revealed it at question_end
+type ReplayAnswerMessage struct {
+	ProfileName    string `json:"profileName"`
+	QuestionIndex  int    `json:"questionIndex"`
+	SelectedOption int    `json:"selectedOption"`
+	IsCorrect      bool   `json:"isCorrect"`
+	Points         uint16 `json:"points"`
+	// Time the player took to answer in milliseconds
+	TimeTaken int64 `json:"timeTaken"`
+}
+
+// Final result of the match being replayed
+type ReplayResultMessage struct {
+	Winner      string              `json:"winner,omitempty"`
+	WinningTeam int                 `json:"winningTeam,omitempty"`
+	IsDrawn     bool                `json:"isDrawn"`
+	ForfeitedBy string              `json:"forfeitedBy,omitempty"`
+	Players     []MatchPlayerRecord `json:"players"`
+}
+
+// One event of a replay at its offset from the start of the match
+type replayEvent struct {
+	offset time.Duration
+	event  string
+	data   interface{}
+}
+
+// Replays running on every connection, a connection watches one replay at a time
+var (
+	replaysMu sync.Mutex
+	replays   = make(map[*Connection]chan struct{})
+)
+
+// Reads the record of the match with the hex id
+func findMatchRecord(ctx context.Context, matchId string) (MatchRecord, error) {
+	id, err := primitive.ObjectIDFromHex(matchId)
+	if err != nil {
+		return MatchRecord{}, errMatchNotFound
+	}
+	var record MatchRecord
+	err = matchesCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return MatchRecord{}, errMatchNotFound
+	}
+	return record, err
+}
+
+// Getting the record of a match with every question, answer and points progression
+func getMatch(w http.ResponseWriter, r *http.Request) {
+	record, err := findMatchRecord(r.Context(), r.PathValue("id"))
+	if err == errMatchNotFound {
+		http.Error(w, "Match not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to find match", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		http.Error(w, "Failed to encode match", http.StatusInternalServerError)
+	}
+}
+
+// Lays out the events of the recorded match in the order they happened
+func (record *MatchRecord) replayEvents() []replayEvent {
+	var events []replayEvent
+	since := func(t time.Time) time.Duration {
+		return t.Sub(record.StartedAt)
+	}
+	for index, round := range record.Rounds {
+		questionId, _ := primitive.ObjectIDFromHex(round.QuestionId)
+		events = append(events, replayEvent{since(round.StartedAt), "question_start", QuestionStartMessage{
+			QuestionIndex: index,
+			Question:      Question{ID: questionId, Category: round.Category, Question: round.Question, Options: round.Options},
+			Deadline:      round.StartedAt.Add(questionDuration).UnixMilli(),
+			Duration:      questionDuration.Milliseconds(),
+		}})
+		for _, player := range record.Players {
+			if index >= len(player.Answers) || player.Answers[index].TimedOut {
+				continue
+			}
+			answer := player.Answers[index]
+			events = append(events, replayEvent{since(answer.ReceivedAt), "player_answered", ReplayAnswerMessage{
+				ProfileName:    player.ProfileName,
+				QuestionIndex:  index,
+				SelectedOption: answer.SelectedOption,
+				IsCorrect:      answer.IsCorrect,
+				Points:         answer.Points,
+				TimeTaken:      answer.TimeTaken.Milliseconds(),
+			}})
+		}
+		// The match ended (forfeit) before this question was over
+		if round.EndedAt.IsZero() {
+			continue
+		}
+		endMessage := QuestionEndMessage{
+			QuestionIndex: index,
+			QuestionId:    round.QuestionId,
+			CorrectOption: round.CorrectOption,
+			Points:        make(map[string]uint16),
+		}
+		for _, player := range record.Players {
+			if index+1 < len(player.PointsProgression) {
+				endMessage.Points[player.ProfileName] = player.PointsProgression[index+1]
+			}
+		}
+		events = append(events, replayEvent{since(round.EndedAt), "question_end", endMessage})
+	}
+	events = append(events, replayEvent{since(record.EndedAt), "match_result", ReplayResultMessage{
+		Winner:      record.Winner,
+		WinningTeam: record.WinningTeam,
+		IsDrawn:     record.IsDrawn,
+		ForfeitedBy: record.ForfeitedBy,
+		Players:     record.Players,
+	}})
+	// Answers are received while their question runs so only events of the same time can be out of order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].offset < events[j].offset
+	})
+	return events
+}
+
+// Streams the recorded match to the connection with its original timing divided by the speed
+// A replay already running on the connection is stopped first
+func startReplay(conn *Connection, matchId string, speed float64) error {
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < 0 || speed > maxReplaySpeed {
+		return errInvalidReplaySpeed
+	}
+	record, err := findMatchRecord(context.TODO(), matchId)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	replaysMu.Lock()
+	if previous, replaying := replays[conn]; replaying {
+		close(previous)
+	}
+	replays[conn] = stop
+	replaysMu.Unlock()
+
+	startMessage := ReplayStartMessage{
+		MatchId:       matchId,
+		Mode:          record.Mode,
+		QuestionCount: len(record.Rounds),
+		Speed:         speed,
+	}
+	for _, player := range record.Players {
+		startMessage.Players = append(startMessage.Players, player.ProfileName)
+	}
+	if err := conn.Send("replay_start", startMessage); err != nil {
+		stopReplay(conn)
+		return err
+	}
+	go runReplay(conn, stop, matchId, record.replayEvents(), speed)
+	return nil
+}
+
+// Sends the events one by one until the replay is over, stopped or the connection closed
+func runReplay(conn *Connection, stop chan struct{}, matchId string, events []replayEvent, speed float64) {
+	defer func() {
+		replaysMu.Lock()
+		if replays[conn] == stop {
+			delete(replays, conn)
+		}
+		replaysMu.Unlock()
+	}()
+
+	var previous time.Duration
+	for _, event := range events {
+		timer := time.NewTimer(time.Duration(float64(event.offset-previous) / speed))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		case <-conn.closed:
+			timer.Stop()
+			return
+		}
+		previous = event.offset
+		if err := conn.Send("replay_event", ReplayEventMessage{
+			MatchId: matchId,
+			Event:   event.event,
+			At:      event.offset.Milliseconds(),
+			Data:    event.data,
+		}); err != nil {
+			return
+		}
+	}
+	conn.Send("replay_end", ReplayEndMessage{MatchId: matchId})
+}
+
+// Stops the replay running on the connection
+func stopReplay(conn *Connection) {
+	replaysMu.Lock()
+	defer replaysMu.Unlock()
+	if stop, replaying := replays[conn]; replaying {
+		close(stop)
+		delete(replays, conn)
+	}
+}
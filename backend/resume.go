@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+)
+
+// Length of the resume token given to every player when the match starts
+const resumeTokenLength = 32
+
+var errInvalidResumeToken = errors.New("resume token is not valid for this room")
+
+// Sent to the other players when a player's socket drops and when the player comes back
+type PlayerConnectionMessage struct {
+	ProfileName string `json:"profileName"`
+	// Unix milliseconds until the seat is held, only set for player_disconnected
+	GraceEndsAt int64 `json:"graceEndsAt,omitempty"`
+}
+
+// Sent to a player who resumed with everything needed to show the match again
+type ResumeMessage struct {
+	RoomId        string   `json:"roomId"`
+	Mode          string   `json:"mode"`
+	Ranked        bool     `json:"ranked"`
+	Players       []string `json:"players"`
+	Team          int      `json:"team,omitempty"`
+	QuestionCount int      `json:"questionCount"`
+	// Index of the running question (-1 before the first question) with its deadline in unix milliseconds
+	QuestionIndex int       `json:"questionIndex"`
+	Question      *Question `json:"question,omitempty"`
+	Deadline      int64     `json:"deadline,omitempty"`
+	// The player already answered the running question
+	Answered bool `json:"answered"`
+	// Answers of the player so far
+	Answers []AnswerRecord `json:"answers"`
+	// Points progression of every player in the room
+	Points    map[string][]uint16 `json:"points"`
+	Standings []Standing          `json:"standings"`
+	Completed bool                `json:"completed"`
+}
+
+// Checks if the match of the room is being played (questions drawn and no result yet)
+// The caller must hold the room lock
+func (room *Room) inProgress() bool {
+	return !room.StartedAt.IsZero() && !room.Completed && !room.Closed
+}
+
+// Holds the seat of a player whose socket dropped during a match
+// The player can resume with its resume token until the grace period is over, after that it leaves the room
+// Returns false when the match is not in progress so the caller leaves the room right away
+func holdSeat(room *Room, profileName string, conn *Connection) bool {
+	room.mu.Lock()
+	player := room.player(profileName)
+	if player == nil || player.Connection != conn || !room.inProgress() {
+		room.mu.Unlock()
+		return false
+	}
+	player.Connection = nil
+	player.DisconnectedAt = time.Now()
+	disconnectedAt := player.DisconnectedAt
+	graceEndsAt := disconnectedAt.Add(config.ResumeGracePeriod)
+	for _, other := range room.Players {
+		if err := other.send("player_disconnected", PlayerConnectionMessage{
+			ProfileName: profileName,
+			GraceEndsAt: graceEndsAt.UnixMilli(),
+		}); err != nil {
+			log.Printf("Error sending disconnect of %s to %s\n", profileName, other.ProfileName)
+		}
+	}
+	room.mu.Unlock()
+
+	time.AfterFunc(config.ResumeGracePeriod, func() {
+		room.mu.Lock()
+		player := room.player(profileName)
+		// Resumed (or dropped again later) in the meantime
+		stillGone := player != nil && player.Connection == nil && player.DisconnectedAt.Equal(disconnectedAt)
+		room.mu.Unlock()
+		if stillGone {
+			log.Printf("%s did not come back to room %s\n", profileName, room.ID)
+			leaveRoom(profileName)
+		}
+	})
+	return true
+}
+
+// Reattaches the new socket of the player to its seat and sends the current state of the match
+func resumeSeat(roomId string, profileName string, resumeToken string, conn *Connection) error {
+	room, exists := rooms.Get(roomId)
+	if !exists {
+		return errRoomNotFound
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	player := room.player(profileName)
+	if player == nil || player.ResumeToken == "" || subtle.ConstantTimeCompare([]byte(player.ResumeToken), []byte(resumeToken)) != 1 {
+		return errInvalidResumeToken
+	}
+	// A seat still held by another live socket (another tab) is taken over by the new socket
+	player.Connection = conn
+	player.DisconnectedAt = time.Time{}
+
+	if err := player.send("resumed", room.resumeMessage(player)); err != nil {
+		log.Printf("Error sending resume state to %s\n", profileName)
+	}
+	for _, other := range room.Players {
+		if other.ProfileName == profileName {
+			continue
+		}
+		if err := other.send("player_reconnected", PlayerConnectionMessage{ProfileName: profileName}); err != nil {
+			log.Printf("Error sending reconnect of %s to %s\n", profileName, other.ProfileName)
+		}
+	}
+	return nil
+}
+
+// Builds the state of the match for a player who resumed
+// The caller must hold the room lock
+func (room *Room) resumeMessage(player *PlayerInfo) ResumeMessage {
+	message := ResumeMessage{
+		RoomId:        room.ID,
+		Mode:          room.Mode,
+		Ranked:        room.Ranked,
+		Team:          player.Team,
+		QuestionCount: len(room.Questions),
+		QuestionIndex: room.CurrentQuestion,
+		Answers:       player.Answers,
+		Points:        make(map[string][]uint16),
+		Standings:     room.standings(),
+		Completed:     room.Completed,
+	}
+	for i := range room.Players {
+		message.Players = append(message.Players, room.Players[i].ProfileName)
+		message.Points[room.Players[i].ProfileName] = pointsProgression(&room.Players[i])
+	}
+	// The running question is only sent while it can still be answered
+	if room.CurrentQuestion >= 0 && !room.Completed && time.Now().Before(room.QuestionDeadline) {
+		question := room.Questions[room.CurrentQuestion]
+		message.Question = &question
+		message.Deadline = room.QuestionDeadline.UnixMilli()
+		message.Answered = len(player.Answers) > room.CurrentQuestion
+	}
+	return message
+}
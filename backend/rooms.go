@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errAlreadyInRoom = errors.New("player is already in a room")
+
+// Modes a room can be played in
+const (
+	// Two players against each other
+	modeDuel = "duel"
+	// 3 to 8 players each playing for themselves, ranked by their points at the end
+	modeFreeForAll = "ffa"
+	// Two teams of two players, the points of the members add up to the team points
+	modeTeams = "team"
+)
+
+// Limits of the number of players in one room
+const (
+	minRoomPlayers = 2
+	maxRoomPlayers = 8
+)
+
+// Room struct to hold the players in a room and the questions drawn for the match
+type Room struct {
+	ID string
+	// Protects everything below since the players and the timer goroutine use the room at the same time
+	// Lock order: RoomManager.mu before Room.mu, never the other way round
+	mu      sync.Mutex
+	Players []PlayerInfo
+	// Every player gets the same questions in the same order
+	Questions []Question
+	// Time when the match was found
+	StartedAt time.Time
+	// Index of the question currently running on the server timer (-1 before the first question)
+	CurrentQuestion   int
+	QuestionStartedAt time.Time
+	QuestionDeadline  time.Time
+	// Signals the timer that every player answered the current question
+	answered chan struct{}
+	// Set once the result is saved so the trophies are never updated twice
+	Completed bool
+	// Set when a player leaves so the timer goroutine stops
+	Closed bool
+	// Only ranked matches change trophies and ratings (matches against bots and private matches are unranked by default)
+	Ranked bool
+	// modeDuel, modeFreeForAll or modeTeams
+	Mode string
+	// Code a friend uses to join a private room, empty for matchmaking rooms
+	InviteCode string
+	// Number of players a private room waits for before the match starts
+	MaxPlayers int
+	// Players who asked for a rematch after the match finished, the rematch starts once every player asked
+	RematchRequests map[string]bool
+	// Incremented for every rematch played in the room
+	MatchNumber int
+	// Read-only connections watching the match, kept apart from the players so they never count as players
+	Spectators []PlayerInfo
+	// Id of the match record of the current match, every rematch gets a new one
+	MatchId primitive.ObjectID
+	// Every question asked so far with its timing, saved with the match record
+	Rounds []MatchRound
+	// Records of the players who left a free-for-all match early, saved with the match record once the others finish
+	Departed []MatchPlayerRecord
+}
+
+// Creates a room for the matched players
+func newRoom(roomId string, players ...PlayerInfo) *Room {
+	return &Room{
+		ID:              roomId,
+		Players:         players,
+		CurrentQuestion: -1,
+		answered:        make(chan struct{}, 1),
+		Mode:            modeDuel,
+		MaxPlayers:      len(players),
+		RematchRequests: make(map[string]bool),
+	}
+}
+
+// RoomManager owns every room with a match in progress and the private rooms waiting for the invited friend
+// Players still waiting for a random opponent are in the MatchmakingQueue
+// All websocket goroutines go through it instead of touching a shared map so rooms can be created and left concurrently
+type RoomManager struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+	// Room of every profile currently in a room so a profile can only be in one room at a time
+	profileRooms map[string]string
+	// Room id of every private room still waiting for the invited friend
+	inviteCodes map[string]string
+	// Room id watched by every spectating connection
+	spectating map[*Connection]string
+}
+
+// Creates an empty room manager
+func NewRoomManager() *RoomManager {
+	return &RoomManager{
+		rooms:        make(map[string]*Room),
+		profileRooms: make(map[string]string),
+		inviteCodes:  make(map[string]string),
+		spectating:   make(map[*Connection]string),
+	}
+}
+
+// Room manager used by the websocket handlers
+var rooms = NewRoomManager()
+
+// Create registers a new room for players matched by the matchmaking queue
+func (m *RoomManager) Create(mode string, ranked bool, players ...PlayerInfo) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, player := range players {
+		if _, inRoom := m.profileRooms[player.ProfileName]; inRoom {
+			return nil, errAlreadyInRoom
+		}
+	}
+
+	// Create a new room with a unique room id
+	roomId, err := generateRandomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	room := newRoom(roomId, players...)
+	room.Mode = mode
+	room.Ranked = ranked
+	m.rooms[roomId] = room
+	for _, player := range players {
+		m.profileRooms[player.ProfileName] = roomId
+	}
+	return room, nil
+}
+
+// Leave removes the room of the player (when a player leaves a duel the match can't go on)
+// Returns the room so the caller can stop it
+func (m *RoomManager) Leave(profileName string) (*Room, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roomId, inRoom := m.profileRooms[profileName]
+	if !inRoom {
+		return nil, false
+	}
+	room := m.rooms[roomId]
+	m.removeLocked(room)
+	return room, true
+}
+
+// RemovePlayer takes only the player out of a free-for-all room whose match is still running
+// The others keep playing as long as at least two players are left, returns false when the whole room has to be left instead
+func (m *RoomManager) RemovePlayer(profileName string) (*Room, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roomId, inRoom := m.profileRooms[profileName]
+	if !inRoom {
+		return nil, false
+	}
+	room := m.rooms[roomId]
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.Completed || room.Closed || !room.canRemovePlayer() {
+		return nil, false
+	}
+	m.removePlayerLocked(room, profileName)
+	return room, true
+}
+
+// Checks if the match of the room can go on without one of its players
+// The caller must hold the room lock
+func (room *Room) canRemovePlayer() bool {
+	return room.Mode == modeFreeForAll && len(room.Players) > minRoomPlayers
+}
+
+// Takes the player out of the room and frees it so it can join another room
+// The caller must hold the manager lock and the room lock
+func (m *RoomManager) removePlayerLocked(room *Room, profileName string) {
+	for i := range room.Players {
+		if room.Players[i].ProfileName == profileName {
+			room.Players = append(room.Players[:i], room.Players[i+1:]...)
+			break
+		}
+	}
+	delete(m.profileRooms, profileName)
+	// The player who left may have been the last one the current question was waiting for
+	if room.CurrentQuestion >= 0 && room.allAnswered(room.CurrentQuestion) {
+		select {
+		case room.answered <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RoomOf returns the room the profile is currently in
+func (m *RoomManager) RoomOf(profileName string) (*Room, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roomId, inRoom := m.profileRooms[profileName]
+	if !inRoom {
+		return nil, false
+	}
+	return m.rooms[roomId], true
+}
+
+// Get returns the room with the given room id
+func (m *RoomManager) Get(roomId string) (*Room, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	room, exists := m.rooms[roomId]
+	return room, exists
+}
+
+// Complete removes a finished room
+// Must be called without holding the room lock
+func (m *RoomManager) Complete(roomId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if room, exists := m.rooms[roomId]; exists {
+		m.removeLocked(room)
+	}
+}
+
+// Removes the room and frees its players so they can join another room
+// The caller must hold the manager lock
+func (m *RoomManager) removeLocked(room *Room) {
+	delete(m.rooms, room.ID)
+	room.mu.Lock()
+	if m.inviteCodes[room.InviteCode] == room.ID {
+		delete(m.inviteCodes, room.InviteCode)
+	}
+	for _, player := range room.Players {
+		if m.profileRooms[player.ProfileName] == room.ID {
+			delete(m.profileRooms, player.ProfileName)
+		}
+	}
+	for _, spectator := range room.Spectators {
+		if m.spectating[spectator.Connection] == room.ID {
+			delete(m.spectating, spectator.Connection)
+		}
+	}
+	room.mu.Unlock()
+}
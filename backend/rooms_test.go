@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Longest a concurrent test may run before it is considered deadlocked
+const deadlockTimeout = 10 * time.Second
+
+// Connection without a socket whose messages are only buffered, large enough that the tests never fill it
+func testConnection() *Connection {
+	return &Connection{
+		send:   make(chan Envelope, 1024),
+		closed: make(chan struct{}),
+	}
+}
+
+// Waits for the goroutines and fails the test when they don't finish, a lock order violation shows up as a deadlock
+func waitOrDeadlock(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(deadlockTimeout):
+		t.Fatal("goroutines did not finish, the room manager and room locks are probably taken in the wrong order")
+	}
+}
+
+// Rooms are created, looked up, watched and left from many goroutines while timer-like goroutines hold the room locks
+func TestRoomManagerConcurrent(t *testing.T) {
+	manager := NewRoomManager()
+	const pairs = 20
+	const rounds = 50
+
+	// Rooms the spectators can watch for the whole test
+	var watched []*Room
+	for i := 0; i < 4; i++ {
+		room, err := manager.Create(modeDuel, false, PlayerInfo{ProfileName: fmt.Sprintf("watched-%d-a", i)}, PlayerInfo{ProfileName: fmt.Sprintf("watched-%d-b", i)})
+		if err != nil {
+			t.Fatalf("creating watched room: %v", err)
+		}
+		watched = append(watched, room)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < pairs; i++ {
+		a := PlayerInfo{ProfileName: fmt.Sprintf("player-%d-a", i), Connection: testConnection()}
+		b := PlayerInfo{ProfileName: fmt.Sprintf("player-%d-b", i), Connection: testConnection()}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				room, err := manager.Create(modeDuel, false, a, b)
+				if err != nil {
+					t.Errorf("creating room of %s: %v", a.ProfileName, err)
+					return
+				}
+				if found, inRoom := manager.RoomOf(b.ProfileName); !inRoom || found != room {
+					t.Errorf("%s is not in its new room", b.ProfileName)
+					return
+				}
+				if left, inRoom := manager.Leave(a.ProfileName); !inRoom || left != room {
+					t.Errorf("%s could not leave its room", a.ProfileName)
+					return
+				}
+				if _, inRoom := manager.RoomOf(b.ProfileName); inRoom {
+					t.Errorf("%s is still in a room after it was left", b.ProfileName)
+					return
+				}
+			}
+		}()
+
+		// A spectator switches between the watched rooms while the rooms of the players come and go
+		spectator := PlayerInfo{ProfileName: fmt.Sprintf("spectator-%d", i), Connection: testConnection()}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				room := watched[round%len(watched)]
+				if _, err := manager.Spectate(room.ID, spectator); err != nil {
+					t.Errorf("spectating room %s: %v", room.ID, err)
+					return
+				}
+			}
+			manager.StopSpectating(spectator.Connection)
+		}()
+	}
+
+	// The question timer only ever takes the room lock, it must never wait for the manager
+	for _, room := range watched {
+		wg.Add(1)
+		go func(room *Room) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				room.mu.Lock()
+				room.broadcast("question_end", nil)
+				room.mu.Unlock()
+			}
+		}(room)
+	}
+	waitOrDeadlock(t, &wg)
+
+	for _, room := range watched {
+		if len(room.Spectators) != 0 {
+			t.Errorf("room %s still has %d spectators after they stopped", room.ID, len(room.Spectators))
+		}
+	}
+	if len(manager.spectating) != 0 {
+		t.Errorf("%d connections are still spectating", len(manager.spectating))
+	}
+	if len(manager.rooms) != len(watched) {
+		t.Errorf("expected %d rooms left, got %d", len(watched), len(manager.rooms))
+	}
+}
+
+// A profile can only be in one room even when several matches try to seat it at the same time
+func TestRoomManagerCreateSameProfile(t *testing.T) {
+	manager := NewRoomManager()
+	shared := PlayerInfo{ProfileName: "shared"}
+	const attempts = 50
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := manager.Create(modeDuel, false, shared, PlayerInfo{ProfileName: fmt.Sprintf("opponent-%d", i)})
+			if err == errAlreadyInRoom {
+				return
+			}
+			if err != nil {
+				t.Errorf("creating room: %v", err)
+				return
+			}
+			mu.Lock()
+			created++
+			mu.Unlock()
+		}(i)
+	}
+	waitOrDeadlock(t, &wg)
+
+	if created != 1 {
+		t.Errorf("expected exactly one room with the shared profile, got %d", created)
+	}
+}
+
+// Every player enqueued from many goroutines ends up matched, removed or still waiting, exactly once
+func TestMatchmakingQueueConcurrent(t *testing.T) {
+	// Everyone has the same rating so every pair fits the search window
+	queue := NewMatchmakingQueue(MatchmakingSettings{
+		BaseWindow:     100,
+		WindowInterval: time.Minute,
+		MaxWait:        time.Hour,
+		QueueTimeout:   time.Hour,
+	})
+	const players = 200
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	outcomes := make(map[string]int)
+	record := func(profileName string) {
+		mu.Lock()
+		outcomes[profileName]++
+		mu.Unlock()
+	}
+	for i := 0; i < players; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			player := PlayerInfo{ProfileName: fmt.Sprintf("player-%d", i)}
+			opponent, position, err := queue.Enqueue(player, defaultRating)
+			if err != nil {
+				t.Errorf("enqueueing %s: %v", player.ProfileName, err)
+				return
+			}
+			if opponent != nil {
+				record(player.ProfileName)
+				record(opponent.ProfileName)
+				return
+			}
+			if position < 1 {
+				t.Errorf("%s is waiting at position %d", player.ProfileName, position)
+			}
+			// Every other waiting player gives up, it may have been matched in the meantime
+			if i%2 == 0 {
+				if removed, queued := queue.Remove(player.ProfileName); queued {
+					record(removed.ProfileName)
+				}
+			}
+		}(i)
+	}
+	waitOrDeadlock(t, &wg)
+
+	for _, player := range queue.Waiting() {
+		record(player.ProfileName)
+	}
+	for i := 0; i < players; i++ {
+		profileName := fmt.Sprintf("player-%d", i)
+		if outcomes[profileName] != 1 {
+			t.Errorf("%s was matched, removed or left waiting %d times", profileName, outcomes[profileName])
+		}
+	}
+}
+
+// Two players of a 3-player free-for-all match forfeit at the same time, only one of them can leave the match going on
+// The other one ends the match (forfeitMatch falls back to it) and nobody stays seated after leaving
+func TestForfeitFreeForAllConcurrent(t *testing.T) {
+	for round := 0; round < 100; round++ {
+		manager := NewRoomManager()
+		room, err := manager.Create(modeFreeForAll, false, PlayerInfo{ProfileName: "a"}, PlayerInfo{ProfileName: "b"}, PlayerInfo{ProfileName: "c"})
+		if err != nil {
+			t.Fatalf("creating room: %v", err)
+		}
+		room.StartedAt = time.Now()
+
+		var wg sync.WaitGroup
+		left := make([]bool, 2)
+		for i, profileName := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(i int, profileName string) {
+				defer wg.Done()
+				_, left[i] = manager.ForfeitFreeForAll(room, profileName)
+			}(i, profileName)
+		}
+		waitOrDeadlock(t, &wg)
+
+		if left[0] == left[1] {
+			t.Fatalf("expected exactly one player to leave the match going on, got %v", left)
+		}
+		leaver, stayer := "a", "b"
+		if left[1] {
+			leaver, stayer = "b", "a"
+		}
+		if room.player(leaver) != nil {
+			t.Errorf("%s is still seated after leaving", leaver)
+		}
+		if _, inRoom := manager.RoomOf(leaver); inRoom {
+			t.Errorf("%s is still in the room after leaving", leaver)
+		}
+		if room.player(stayer) == nil || len(room.Players) != minRoomPlayers {
+			t.Errorf("expected %s and c to be left in the room, got %d players", stayer, len(room.Players))
+		}
+		if len(room.Departed) != 1 || room.Departed[0].ProfileName != leaver {
+			t.Errorf("expected only %s to be departed, got %d departed players", leaver, len(room.Departed))
+		}
+	}
+}
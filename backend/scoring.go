@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Points awarded for every correct answer (5 questions so a perfect score is 100)
+	pointsPerCorrectAnswer = 20
+	// Answering correctly within this time unlocks the Lightning Reflexes achievement
+	lightningReflexesTime = 3 * time.Second
+	// Winning after being behind by more than this many points unlocks the Clutch Performer achievement
+	clutchPointsDeficit = 40
+	// Trophies of the first place and the last place of a ranked match (the winner and the loser of a duel)
+	trophiesForWin  = 5
+	trophiesForLoss = -3
+)
+
+var (
+	errPlayerNotInRoom    = errors.New("player is not in this room")
+	errAlreadyAnswered    = errors.New("current question is already answered")
+	errWrongQuestion      = errors.New("answer is not for the current question")
+	errDeadlinePassed     = errors.New("answer received after the deadline")
+	errInvalidOption      = errors.New("selected option does not exist")
+	errMatchAlreadyScored = errors.New("match is already completed")
+)
+
+// AnswerRecord holds a single answer submitted by a player
+// Everything except ClientTimestamp is computed on the server
+type AnswerRecord struct {
+	QuestionId string `bson:"questionId" json:"questionId"`
+	// -1 when the player did not answer before the deadline
+	SelectedOption int  `bson:"selectedOption" json:"selectedOption"`
+	IsCorrect      bool `bson:"isCorrect" json:"isCorrect"`
+	TimedOut       bool `bson:"timedOut,omitempty" json:"timedOut,omitempty"`
+	// Total points of the player after this answer
+	Points uint16 `bson:"points" json:"points"`
+	// Time taken to answer measured on the server
+	TimeTaken time.Duration `bson:"timeTaken" json:"timeTaken"`
+	// Timestamp sent by the client in unix milliseconds, only kept for debugging and never used for scoring
+	ClientTimestamp int64     `bson:"clientTimestamp" json:"clientTimestamp"`
+	ReceivedAt      time.Time `bson:"receivedAt" json:"receivedAt"`
+}
+
+// Standing is the place of a player in the room, sent live after every question and with the final result
+type Standing struct {
+	ProfileName string `json:"profileName"`
+	Points      uint16 `json:"points"`
+	// Players with the same points share the place (1, 1, 3, ...), in team matches every member gets the place of its team
+	Place int `json:"place"`
+	// Team of the player in team matches
+	Team int `json:"team,omitempty"`
+	// Trophies won or lost for the place, only set in the final standings
+	Trophies int `json:"trophies"`
+}
+
+// MatchResult holds everything derived from the answers recorded on the server once every player is done
+type MatchResult struct {
+	// Only set when a single player finished first (never in team matches)
+	Winner string
+	// Team with more points in team matches, 0 for a draw
+	WinningTeam int
+	TeamPoints  map[int]uint16
+	// Every player (or both teams) finished with the same points
+	IsDrawn bool
+	// Every player from the first to the last place
+	Standings []Standing
+	// modeDuel, modeFreeForAll or modeTeams
+	Mode string
+	// Profile names of the players who unlocked each achievement in this match
+	PerfectScore      map[string]bool
+	LightningReflexes map[string]bool
+	ClutchPerformer   string
+	// Only ranked matches change trophies, ratings and achievements
+	Ranked bool
+	// Profile names of the bots in the match (they have no profile to update)
+	Bots map[string]bool
+	// Player who abandoned the match
+	ForfeitedBy string
+	// Players of a free-for-all match who keep playing after a player forfeited, their result is recorded when the match ends
+	StillPlaying map[string]bool
+	// Players who left the free-for-all match early, in the order they left, their ratings change with the others once the match ends
+	Departed []string
+	// Id of the match record, saved in the history of every player
+	MatchId primitive.ObjectID
+}
+
+// Returns the player with the given profile name in the room
+func (room *Room) player(profileName string) *PlayerInfo {
+	for i := range room.Players {
+		if room.Players[i].ProfileName == profileName {
+			return &room.Players[i]
+		}
+	}
+	return nil
+}
+
+// Checks the answer against the question bank and records it for the player
+// The caller must hold the room lock
+func (room *Room) recordAnswer(profileName string, questionId string, selectedOption int, clientTimestamp int64) (AnswerRecord, error) {
+	if room.Completed || room.Closed {
+		return AnswerRecord{}, errMatchAlreadyScored
+	}
+	player := room.player(profileName)
+	if player == nil {
+		return AnswerRecord{}, errPlayerNotInRoom
+	}
+	// Only the question started by the server timer can be answered
+	questionIndex := room.CurrentQuestion
+	if questionIndex < 0 {
+		return AnswerRecord{}, errWrongQuestion
+	}
+	if len(player.Answers) > questionIndex {
+		return AnswerRecord{}, errAlreadyAnswered
+	}
+	question := room.Questions[questionIndex]
+	if question.ID.Hex() != questionId {
+		return AnswerRecord{}, errWrongQuestion
+	}
+	if selectedOption < 0 || selectedOption >= len(question.Options) {
+		return AnswerRecord{}, errInvalidOption
+	}
+
+	receivedAt := time.Now()
+	if receivedAt.After(room.QuestionDeadline) {
+		return AnswerRecord{}, errDeadlinePassed
+	}
+
+	isCorrect := selectedOption == question.CorrectOption
+	if isCorrect {
+		player.PlayerPoints += pointsPerCorrectAnswer
+	}
+	answer := AnswerRecord{
+		QuestionId:      questionId,
+		SelectedOption:  selectedOption,
+		IsCorrect:       isCorrect,
+		Points:          player.PlayerPoints,
+		TimeTaken:       receivedAt.Sub(room.QuestionStartedAt),
+		ClientTimestamp: clientTimestamp,
+		ReceivedAt:      receivedAt,
+	}
+	player.Answers = append(player.Answers, answer)
+
+	// Let the timer move to the next question early when every player has answered
+	if room.allAnswered(questionIndex) {
+		select {
+		case room.answered <- struct{}{}:
+		default:
+		}
+	}
+	return answer, nil
+}
+
+// Checks if every player in the room answered the question at the given index
+func (room *Room) allAnswered(questionIndex int) bool {
+	for i := range room.Players {
+		if len(room.Players[i].Answers) <= questionIndex {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks if the player answered every question in the room
+func (room *Room) hasFinished(player *PlayerInfo) bool {
+	return len(player.Answers) >= len(room.Questions)
+}
+
+// Checks if every player in the room answered every question
+func (room *Room) allFinished() bool {
+	if len(room.Players) < 2 {
+		return false
+	}
+	for i := range room.Players {
+		if !room.hasFinished(&room.Players[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Points of the player after every question starting with 0 ([0,20,40,...]) same format the client used to send
+func pointsProgression(player *PlayerInfo) []uint16 {
+	points := []uint16{0}
+	for _, answer := range player.Answers {
+		points = append(points, answer.Points)
+	}
+	return points
+}
+
+// Orders the players by their points, players with the same points share the place
+// In team matches the players are ordered by the points of their team first and every member gets the place of its team
+// The caller must hold the room lock
+func (room *Room) standings() []Standing {
+	standings := make([]Standing, len(room.Players))
+	for i := range room.Players {
+		standings[i] = Standing{ProfileName: room.Players[i].ProfileName, Points: room.Players[i].PlayerPoints, Team: room.Players[i].Team}
+	}
+	// Without teams every player is on team 0 so only the points of the players count
+	teamPoints := room.teamPoints()
+	sort.SliceStable(standings, func(i, j int) bool {
+		if teamPoints[standings[i].Team] != teamPoints[standings[j].Team] {
+			return teamPoints[standings[i].Team] > teamPoints[standings[j].Team]
+		}
+		return standings[i].Points > standings[j].Points
+	})
+	for i := range standings {
+		if room.Mode == modeTeams {
+			standings[i].Place = 1
+			if teamPoints[standings[i].Team] < teamPoints[standings[0].Team] {
+				standings[i].Place = 2
+			}
+		} else if i > 0 && standings[i].Points == standings[i-1].Points {
+			standings[i].Place = standings[i-1].Place
+		} else {
+			standings[i].Place = i + 1
+		}
+	}
+	return standings
+}
+
+// Result of the player for the history ("Won", "Lost", "Draw" or "Forfeit")
+// A player sharing the first place with others in a free-for-all match has a draw
+func (result MatchResult) outcome(standing Standing) string {
+	switch {
+	case standing.ProfileName == result.ForfeitedBy:
+		return "Forfeit"
+	case result.IsDrawn:
+		return "Draw"
+	case result.Mode == modeTeams && standing.Team == result.WinningTeam:
+		return "Won"
+	case result.Mode == modeTeams:
+		return "Lost"
+	case standing.ProfileName == result.Winner:
+		return "Won"
+	case standing.Place == 1:
+		return "Draw"
+	default:
+		return "Lost"
+	}
+}
+
+// Trophies for finishing at the given position (1 is first) out of playerCount players
+// The first place wins trophiesForWin, the last place loses trophiesForLoss and the places in between are spread evenly
+func trophiesForPosition(position int, playerCount int) float64 {
+	if playerCount < 2 {
+		return 0
+	}
+	return trophiesForWin - float64(trophiesForWin-trophiesForLoss)*float64(position-1)/float64(playerCount-1)
+}
+
+// Sets the trophies of every place, players sharing a place split the trophies of the positions they share
+func assignPlacementTrophies(standings []Standing) {
+	for start := 0; start < len(standings); {
+		end := start
+		for end < len(standings) && standings[end].Place == standings[start].Place {
+			end++
+		}
+		var total float64
+		for position := start + 1; position <= end; position++ {
+			total += trophiesForPosition(position, len(standings))
+		}
+		trophies := int(math.Round(total / float64(end-start)))
+		for i := start; i < end; i++ {
+			standings[i].Trophies = trophies
+		}
+		start = end
+	}
+}
+
+// Derives the standings and the achievements of the room from the answers recorded on the server
+// The caller must hold the room lock
+func computeMatchResult(room *Room) MatchResult {
+	result := MatchResult{
+		Standings:         room.standings(),
+		Mode:              room.Mode,
+		MatchId:           room.MatchId,
+		PerfectScore:      make(map[string]bool),
+		LightningReflexes: make(map[string]bool),
+		Ranked:            room.Ranked,
+		Bots:              make(map[string]bool),
+	}
+	for _, player := range room.Players {
+		if player.Bot != nil {
+			result.Bots[player.ProfileName] = true
+		}
+	}
+	for _, departed := range room.Departed {
+		result.Departed = append(result.Departed, departed.ProfileName)
+	}
+
+	standings := result.Standings
+	last := standings[len(standings)-1]
+	if last.Place == 1 {
+		// Everyone (or both teams) has the same points
+		result.IsDrawn = true
+	} else if room.Mode == modeTeams {
+		result.WinningTeam = standings[0].Team
+	} else if standings[1].Place != 1 {
+		result.Winner = standings[0].ProfileName
+	}
+	if room.Mode == modeTeams {
+		result.TeamPoints = room.teamPoints()
+	}
+	// Draws and unranked matches (like bot matches) never change trophies
+	if result.Ranked && !result.IsDrawn {
+		if room.Mode == modeTeams {
+			// Every member of the winning team wins like the winner of a duel
+			for i := range standings {
+				standings[i].Trophies = trophiesForLoss
+				if standings[i].Team == result.WinningTeam {
+					standings[i].Trophies = trophiesForWin
+				}
+			}
+		} else {
+			assignPlacementTrophies(standings)
+		}
+	}
+
+	for i := range room.Players {
+		p := &room.Players[i]
+		isPerfectScore := len(p.Answers) > 0
+		for _, answer := range p.Answers {
+			if !answer.IsCorrect {
+				isPerfectScore = false
+			}
+			// Answered correctly within 3 seconds
+			if answer.IsCorrect && answer.TimeTaken <= lightningReflexesTime {
+				result.LightningReflexes[p.ProfileName] = true
+			}
+		}
+		if isPerfectScore {
+			result.PerfectScore[p.ProfileName] = true
+		}
+	}
+
+	// Check if the winner was behind the leader by more than 40 points at any point of the match
+	if result.Winner != "" {
+		winnerPoints := pointsProgression(room.player(result.Winner))
+		for i := range room.Players {
+			if room.Players[i].ProfileName == result.Winner {
+				continue
+			}
+			otherPoints := pointsProgression(&room.Players[i])
+			for j := 0; j < len(winnerPoints) && j < len(otherPoints); j++ {
+				if int(otherPoints[j])-int(winnerPoints[j]) > clutchPointsDeficit {
+					result.ClutchPerformer = result.Winner
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Room of two players with the first of two questions running, the correct option of every question is 1
+func answeringRoom() *Room {
+	room := newRoom("room", PlayerInfo{ProfileName: "a"}, PlayerInfo{ProfileName: "b"})
+	for i := 0; i < 2; i++ {
+		room.Questions = append(room.Questions, Question{ID: primitive.NewObjectID(), Options: []string{"w", "x", "y", "z"}, CorrectOption: 1})
+	}
+	room.StartedAt = time.Now()
+	room.CurrentQuestion = 0
+	room.QuestionStartedAt = time.Now()
+	room.QuestionDeadline = room.QuestionStartedAt.Add(questionDuration)
+	return room
+}
+
+// Player whose answers are correct or wrong in order, every answer taken after timeTaken
+func answeredPlayer(profileName string, correct []bool, timeTaken time.Duration) PlayerInfo {
+	player := PlayerInfo{ProfileName: profileName}
+	for _, isCorrect := range correct {
+		if isCorrect {
+			player.PlayerPoints += pointsPerCorrectAnswer
+		}
+		player.Answers = append(player.Answers, AnswerRecord{IsCorrect: isCorrect, Points: player.PlayerPoints, TimeTaken: timeTaken})
+	}
+	return player
+}
+
+func TestRecordAnswer(t *testing.T) {
+	tests := []struct {
+		name string
+		// Changes the room before the answer is recorded
+		setup          func(room *Room)
+		profileName    string
+		questionId     func(room *Room) string
+		selectedOption int
+		wantErr        error
+		wantCorrect    bool
+	}{
+		{name: "correct answer", selectedOption: 1, wantCorrect: true},
+		{name: "wrong answer", selectedOption: 2},
+		{
+			name:           "after the deadline",
+			setup:          func(room *Room) { room.QuestionDeadline = time.Now().Add(-time.Millisecond) },
+			selectedOption: 1,
+			wantErr:        errDeadlinePassed,
+		},
+		{
+			name: "duplicate answer",
+			setup: func(room *Room) {
+				room.Players[0].Answers = []AnswerRecord{{QuestionId: room.Questions[0].ID.Hex()}}
+			},
+			selectedOption: 1,
+			wantErr:        errAlreadyAnswered,
+		},
+		{
+			name:           "wrong question id",
+			questionId:     func(room *Room) string { return room.Questions[1].ID.Hex() },
+			selectedOption: 1,
+			wantErr:        errWrongQuestion,
+		},
+		{
+			name:           "before the first question",
+			setup:          func(room *Room) { room.CurrentQuestion = -1 },
+			selectedOption: 1,
+			wantErr:        errWrongQuestion,
+		},
+		{name: "option below the range", selectedOption: -1, wantErr: errInvalidOption},
+		{name: "option above the range", selectedOption: 4, wantErr: errInvalidOption},
+		{name: "player not in the room", profileName: "c", selectedOption: 1, wantErr: errPlayerNotInRoom},
+		{
+			name:           "match already scored",
+			setup:          func(room *Room) { room.Completed = true },
+			selectedOption: 1,
+			wantErr:        errMatchAlreadyScored,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := answeringRoom()
+			if test.setup != nil {
+				test.setup(room)
+			}
+			profileName := test.profileName
+			if profileName == "" {
+				profileName = "a"
+			}
+			questionId := room.Questions[0].ID.Hex()
+			if test.questionId != nil {
+				questionId = test.questionId(room)
+			}
+			answersBefore := len(room.Players[0].Answers)
+
+			answer, err := room.recordAnswer(profileName, questionId, test.selectedOption, 0)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr != nil {
+				if len(room.Players[0].Answers) != answersBefore {
+					t.Errorf("rejected answer was recorded")
+				}
+				return
+			}
+			if answer.IsCorrect != test.wantCorrect {
+				t.Errorf("expected correct %v, got %v", test.wantCorrect, answer.IsCorrect)
+			}
+			wantPoints := uint16(0)
+			if test.wantCorrect {
+				wantPoints = pointsPerCorrectAnswer
+			}
+			if answer.Points != wantPoints || room.Players[0].PlayerPoints != wantPoints {
+				t.Errorf("expected %d points, got %d (player has %d)", wantPoints, answer.Points, room.Players[0].PlayerPoints)
+			}
+			if len(room.Players[0].Answers) != 1 {
+				t.Errorf("expected the answer to be recorded, got %d answers", len(room.Players[0].Answers))
+			}
+		})
+	}
+}
+
+func TestStandings(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		points     []uint16
+		teams      []int
+		wantOrder  []string
+		wantPlaces []int
+	}{
+		{name: "distinct points", mode: modeFreeForAll, points: []uint16{20, 60, 40}, wantOrder: []string{"b", "c", "a"}, wantPlaces: []int{1, 2, 3}},
+		{name: "tied first place", mode: modeFreeForAll, points: []uint16{40, 40, 20}, wantOrder: []string{"a", "b", "c"}, wantPlaces: []int{1, 1, 3}},
+		{name: "tied last place", mode: modeFreeForAll, points: []uint16{60, 20, 20}, wantOrder: []string{"a", "b", "c"}, wantPlaces: []int{1, 2, 2}},
+		{name: "everyone tied", mode: modeDuel, points: []uint16{40, 40}, wantOrder: []string{"a", "b"}, wantPlaces: []int{1, 1}},
+		{
+			name:       "teams ordered by team points",
+			mode:       modeTeams,
+			points:     []uint16{80, 0, 40, 60},
+			teams:      []int{1, 1, 2, 2},
+			wantOrder:  []string{"d", "c", "a", "b"},
+			wantPlaces: []int{1, 1, 2, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var players []PlayerInfo
+			for i, points := range test.points {
+				player := PlayerInfo{ProfileName: string(rune('a' + i)), PlayerPoints: points}
+				if test.teams != nil {
+					player.Team = test.teams[i]
+				}
+				players = append(players, player)
+			}
+			room := newRoom("room", players...)
+			room.Mode = test.mode
+
+			standings := room.standings()
+			for i, standing := range standings {
+				if standing.ProfileName != test.wantOrder[i] || standing.Place != test.wantPlaces[i] {
+					t.Errorf("standing %d: expected %s in place %d, got %s in place %d", i, test.wantOrder[i], test.wantPlaces[i], standing.ProfileName, standing.Place)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignPlacementTrophies(t *testing.T) {
+	tests := []struct {
+		name         string
+		places       []int
+		wantTrophies []int
+	}{
+		{name: "duel", places: []int{1, 2}, wantTrophies: []int{trophiesForWin, trophiesForLoss}},
+		{name: "three distinct places", places: []int{1, 2, 3}, wantTrophies: []int{5, 1, -3}},
+		// The tied players split the trophies of the first and second position
+		{name: "tied first place", places: []int{1, 1, 3}, wantTrophies: []int{3, 3, -3}},
+		{name: "tied last place", places: []int{1, 2, 2}, wantTrophies: []int{5, -1, -1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standings := make([]Standing, len(test.places))
+			for i, place := range test.places {
+				standings[i].Place = place
+			}
+			assignPlacementTrophies(standings)
+			for i, standing := range standings {
+				if standing.Trophies != test.wantTrophies[i] {
+					t.Errorf("place %d: expected %d trophies, got %d", standing.Place, test.wantTrophies[i], standing.Trophies)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeMatchResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		players []PlayerInfo
+		// Zero values are expected to stay unset
+		wantWinner            string
+		wantDrawn             bool
+		wantTrophies          map[string]int
+		wantPerfectScore      []string
+		wantLightningReflexes []string
+		wantClutchPerformer   string
+	}{
+		{
+			name: "duel winner",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{true, true, false}, 5*time.Second),
+				answeredPlayer("b", []bool{false, true, false}, 5*time.Second),
+			},
+			wantWinner:   "a",
+			wantTrophies: map[string]int{"a": trophiesForWin, "b": trophiesForLoss},
+		},
+		{
+			name: "draw",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{true, false}, 5*time.Second),
+				answeredPlayer("b", []bool{false, true}, 5*time.Second),
+			},
+			wantDrawn:    true,
+			wantTrophies: map[string]int{"a": 0, "b": 0},
+		},
+		{
+			name: "free-for-all with a tied first place has no winner and is not a draw",
+			mode: modeFreeForAll,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{true, true}, 5*time.Second),
+				answeredPlayer("b", []bool{true, true}, 5*time.Second),
+				answeredPlayer("c", []bool{false, false}, 5*time.Second),
+			},
+			wantTrophies:     map[string]int{"a": 3, "b": 3, "c": -3},
+			wantPerfectScore: []string{"a", "b"},
+		},
+		{
+			name: "perfect score",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{true, true, true}, 5*time.Second),
+				answeredPlayer("b", []bool{true, false, true}, 5*time.Second),
+			},
+			wantWinner:       "a",
+			wantTrophies:     map[string]int{"a": trophiesForWin, "b": trophiesForLoss},
+			wantPerfectScore: []string{"a"},
+		},
+		{
+			name: "lightning reflexes only for fast correct answers",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{true, false}, lightningReflexesTime),
+				// Fast but wrong answers don't count
+				answeredPlayer("b", []bool{false, false}, time.Second),
+			},
+			wantWinner:            "a",
+			wantTrophies:          map[string]int{"a": trophiesForWin, "b": trophiesForLoss},
+			wantLightningReflexes: []string{"a"},
+		},
+		{
+			name: "clutch performer after trailing by more than the deficit",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{false, false, false, true, true, true, true}, 5*time.Second),
+				answeredPlayer("b", []bool{true, true, true, false, false, false, false}, 5*time.Second),
+			},
+			wantWinner:          "a",
+			wantTrophies:        map[string]int{"a": trophiesForWin, "b": trophiesForLoss},
+			wantClutchPerformer: "a",
+		},
+		{
+			name: "no clutch performer when trailing by exactly the deficit",
+			mode: modeDuel,
+			players: []PlayerInfo{
+				answeredPlayer("a", []bool{false, false, true, true, true}, 5*time.Second),
+				answeredPlayer("b", []bool{true, true, false, false, false}, 5*time.Second),
+			},
+			wantWinner:   "a",
+			wantTrophies: map[string]int{"a": trophiesForWin, "b": trophiesForLoss},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newRoom("room", test.players...)
+			room.Mode = test.mode
+			room.Ranked = true
+
+			result := computeMatchResult(room)
+			if result.Winner != test.wantWinner {
+				t.Errorf("expected winner %q, got %q", test.wantWinner, result.Winner)
+			}
+			if result.IsDrawn != test.wantDrawn {
+				t.Errorf("expected drawn %v, got %v", test.wantDrawn, result.IsDrawn)
+			}
+			for _, standing := range result.Standings {
+				if standing.Trophies != test.wantTrophies[standing.ProfileName] {
+					t.Errorf("expected %d trophies for %s, got %d", test.wantTrophies[standing.ProfileName], standing.ProfileName, standing.Trophies)
+				}
+			}
+			if len(result.PerfectScore) != len(test.wantPerfectScore) {
+				t.Errorf("expected perfect score for %v, got %v", test.wantPerfectScore, result.PerfectScore)
+			}
+			for _, profileName := range test.wantPerfectScore {
+				if !result.PerfectScore[profileName] {
+					t.Errorf("expected perfect score for %s", profileName)
+				}
+			}
+			if len(result.LightningReflexes) != len(test.wantLightningReflexes) {
+				t.Errorf("expected lightning reflexes for %v, got %v", test.wantLightningReflexes, result.LightningReflexes)
+			}
+			for _, profileName := range test.wantLightningReflexes {
+				if !result.LightningReflexes[profileName] {
+					t.Errorf("expected lightning reflexes for %s", profileName)
+				}
+			}
+			if result.ClutchPerformer != test.wantClutchPerformer {
+				t.Errorf("expected clutch performer %q, got %q", test.wantClutchPerformer, result.ClutchPerformer)
+			}
+		})
+	}
+}
@@ -16,18 +16,16 @@ import (
 	"github.com/cloudinary/cloudinary-go/v2/api/admin"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 
 	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// JWT Key
-var jwtKey = []byte("543jkln543nfgdsk43")
-
 type Credentials struct {
 	ProfileName     string `json:"profileName"`
 	ProfilePassword string `json:"profilePassword"`
@@ -37,13 +35,7 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
-// Maximum 10 request in a minute
-const (
-	requestLimit = 1000
-
-	timeWindow = 60 * time.Second
-)
-
+// Maximum config.RequestLimit requests within config.RequestWindow
 var (
 	clientRequests = make(map[string][]time.Time)
 	// using Mutex to synchronize access to the map
@@ -76,14 +68,14 @@ func rateLimitMiddleware(next http.Handler) http.Handler {
 		var validTimestamps []time.Time
 		for _, timestamp := range timestamps {
 			// Only keep the timestamps within the time window
-			if currentTime.Sub(timestamp) < timeWindow {
+			if currentTime.Sub(timestamp) < config.RequestWindow {
 				validTimestamps = append(validTimestamps, timestamp)
 			}
 		}
 
 		// If the number of valid timestamps exceeds the rate limit, block the request
 		// ! check this later (why can't i send text as response)
-		if len(validTimestamps) >= requestLimit {
+		if len(validTimestamps) >= config.RequestLimit {
 			http.Error(w, "Rate limit exceeded. Please try atrophiesGained later.", http.StatusTooManyRequests)
 			return
 		}
@@ -119,7 +111,10 @@ type Profile struct {
 	Status          string `json:"status,omitempty"`
 	Country         string `json:"country,omitempty"`
 	ProfileImageURL string `json:"profileImageURL,omitempty"`
-	Trophies        uint16 `json:"trophies,omitempty"`
+	// int since trophies go below 0 after a few losses
+	Trophies int `json:"trophies,omitempty"`
+	// Elo rating used for matchmaking, trophies stay the visible reward
+	Rating int `json:"rating,omitempty"`
 }
 
 // Achievements struct to hold only the achievements completed so far by the user
@@ -129,15 +124,24 @@ type Achievements struct {
 }
 
 // History Item
-// ! 2 items as of now
+// Duels only set the opponent, free-for-all matches set the mode, the place and every other player instead
+// Team matches set the mode, the teammates and the players of the other team
 type HistoryItem struct {
-	Opponent string `json:"opponent"`
-	Result   string `json:"result"`
-}
-
-// History struct to hold only the matches completed so far by the user
-type History struct {
-	History []HistoryItem `json:"history"`
+	Opponent  string   `bson:"opponent,omitempty" json:"opponent,omitempty"`
+	Result    string   `bson:"result" json:"result"`
+	Mode      string   `bson:"mode,omitempty" json:"mode,omitempty"`
+	Place     int      `bson:"place,omitempty" json:"place,omitempty"`
+	Teammates []string `bson:"teammates,omitempty" json:"teammates,omitempty"`
+	Opponents []string `bson:"opponents,omitempty" json:"opponents,omitempty"`
+	// Player who abandoned the match, its own result is "Forfeit"
+	ForfeitedBy string `bson:"forfeitedBy,omitempty" json:"forfeitedBy,omitempty"`
+	// Id of the record of the match in the matches collection
+	MatchId string `bson:"matchId,omitempty" json:"matchId,omitempty"`
+	// When the match ended, the points of the player and the trophies it won or lost
+	// Entries saved before these were recorded have no timestamp
+	PlayedAt    *time.Time `bson:"playedAt,omitempty" json:"playedAt,omitempty"`
+	Score       uint16     `bson:"score" json:"score"`
+	TrophyDelta int        `bson:"trophyDelta" json:"trophyDelta"`
 }
 
 // Response struct to hold the JSON response message used during sending json message during login
@@ -145,45 +149,80 @@ type Response struct {
 	Message string `json:"message,omitempty"`
 }
 
-// Structure of message from client triggered when joinging and leaving the websocket server used when clearing the map entries
-type Message struct {
-	Action string `json:"action"`
-	// If there is no roomId key from json just omit it dont create a new field and assign 0 dont do that just omit it
-	RoomId                      string   `json:"roomId,omitempty"`
-	ProfileName                 string   `json:"profileName"`
-	PlayerPoints                []uint16 `json:"playerPoints,omitempty"`
-	OpponentName                string   `json:"opponentName,omitempty"`
-	OpponentPoints              []uint16 `json:"opponentTotalPoints,omitempty"`
-	TimeTaken                   uint16   `json:"timeTaken,omitempty"`
-	IsPerfectScore              bool     `json:"isPerfectScore,omitempty"`
-	IsLightingReflexesCompleted bool     `json:"isLightingReflexesCompleted,omitempty"`
-	IsClutchPerformer           string   `json:"isClutchPerformer,omitempty"`
-}
-
 // Defining a struct to hold both the websocket connection and its profile name
 // ! changed playerpoints dt
 type PlayerInfo struct {
-	Connection   *websocket.Conn
+	Connection   *Connection
 	ProfileName  string
 	PlayerPoints uint16
+	// Answers submitted by the player in question order
+	Answers []AnswerRecord
+	// Set for server-side bot opponents which have no connection
+	Bot *BotProfile
+	// Team of the player in team matches (1 or 2), 0 in the other modes
+	Team int
+	// Secret the player sends with resume to get its seat back after its socket dropped
+	ResumeToken string
+	// Set while the socket of the player is gone and its seat is held
+	DisconnectedAt time.Time
 }
 
-// A map to store room id as key and array of 2 strings as profile name of two players
-var playersInQueue = make(map[string][]PlayerInfo)
-
-// Connect to MongoDB and set the quiz database and profile collection
-func connectMongoDB() {
-	// Load environment variables from .env file
-	envErr := godotenv.Load()
-	if envErr != nil {
-		log.Fatal("Error loading .env file")
+// Sends the message to the player, bots have no connection so nothing is sent to them
+func (player *PlayerInfo) send(messageType string, payload interface{}) error {
+	if player.Connection == nil {
+		return nil
 	}
+	return player.Connection.Send(messageType, payload)
+}
 
-	// Get the MongoDB URI from the environment
-	uri := os.Getenv("MONGO_CONNECTION_URI")
-	if uri == "" {
-		log.Fatal("MONGO_URI not set in .env file")
-	}
+// Reply sent to the player after every submitted answer
+type AnswerResultMessage struct {
+	QuestionId string `json:"questionId"`
+	IsCorrect  bool   `json:"isCorrect"`
+	Points     uint16 `json:"points"`
+}
+
+// Message sent to every player once the server has scored the match
+type MatchResultMessage struct {
+	Winner  string `json:"winner,omitempty"`
+	IsDrawn bool   `json:"isDrawn"`
+	// Points progression of every player in the room
+	Points map[string][]uint16 `json:"points"`
+	// Final places with the trophies won or lost
+	Standings []Standing `json:"standings"`
+	// Only set for team matches, the winning team is 0 for a draw
+	WinningTeam int            `json:"winningTeam,omitempty"`
+	TeamPoints  map[int]uint16 `json:"teamPoints,omitempty"`
+	// Player who abandoned the match
+	ForfeitedBy string `json:"forfeitedBy,omitempty"`
+}
+
+// Message sent to every player when the match is found
+// The questions themselves are sent one by one by the server timer (question_start)
+type MatchFoundMessage struct {
+	// Only set for duels
+	Opponent string `json:"opponent,omitempty"`
+	// Every player in the room (including the player itself)
+	Players []string `json:"players"`
+	// Only set for team matches
+	Team          int      `json:"team,omitempty"`
+	Teammates     []string `json:"teammates,omitempty"`
+	Mode          string   `json:"mode"`
+	RoomId        string   `json:"roomId"`
+	QuestionCount int      `json:"questionCount"`
+	// Unix milliseconds when the first question starts
+	StartsAt int64 `json:"startsAt"`
+	// Only ranked matches change trophies and ratings
+	Ranked        bool `json:"ranked"`
+	OpponentIsBot bool `json:"opponentIsBot,omitempty"`
+	// Sent with resume to get the seat back if the socket drops during the match
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+// Connect to MongoDB and set the quiz database and profile collection
+func connectMongoDB(cfg *Config) {
+	// The MongoDB URI comes from the config (MONGO_CONNECTION_URI)
+	uri := cfg.MongoURI
 	// Use the SetServerAPIOptions() method to set the Stable API version to 1
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
@@ -195,10 +234,30 @@ func connectMongoDB() {
 	}
 
 	// Connect to the quiz database and the profile collection
-	collection = client.Database("quiz").Collection("profile")
+	database := client.Database(cfg.DatabaseName)
+	collection = database.Collection("profile")
+
+	// Questions collection used as the question bank for the rooms
+	questionsCollection := database.Collection("questions")
+	seedQuestions(context.TODO(), questionsCollection)
+	questionStore = &mongoQuestionStore{collection: questionsCollection}
+
+	// Sessions collection holding the refresh tokens
+	sessionsCollection = database.Collection("sessions")
+	ensureSessionIndexes(context.TODO())
+
+	// Matches collection holding the record of every completed match
+	matchesCollection = database.Collection("matches")
+	ensureMatchIndexes(context.TODO())
+
+	// Ratings are updated in a transaction when the deployment supports them (replica set or sharded cluster)
+	transactionsSupported = supportsTransactions(context.TODO())
+	if !transactionsSupported {
+		log.Println("MongoDB does not support transactions (not a replica set), ratings are updated without them")
+	}
 
 	// Confirm the connection
-	fmt.Println("Connected to MongoDB, database: quiz, collection: profile")
+	fmt.Printf("Connected to MongoDB, database: %s, collections: profile, questions, sessions, matches\n", cfg.DatabaseName)
 }
 
 // Checking if the profile name already exists
@@ -239,10 +298,7 @@ func checkProfileNameExists(w http.ResponseWriter, r *http.Request) {
 	isRetreiveProfileImage := "false"
 	// If we want to get the profile image url from cloudinary
 	if isRetreiveProfileImage == "true" {
-		cloudName := os.Getenv("CLOUDINARY_CLOUD_NAME")
-		cloudAPIKey := os.Getenv("CLOUDINARY_API_KEY")
-		cloudSecret := os.Getenv("CLOUDINARY_API_SECRET")
-		cld, err := cloudinary.NewFromParams(cloudName, cloudAPIKey, cloudSecret)
+		cld, err := cloudinary.NewFromParams(config.CloudinaryCloudName, config.CloudinaryAPIKey, config.CloudinaryAPISecret)
 		if err != nil {
 			http.Error(w, "Failed to initialize Cloudinary", http.StatusInternalServerError)
 			return
@@ -261,11 +317,28 @@ func checkProfileNameExists(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("profile image url is %v", profileImageURL)
 	}
 
-	// Login is successful both the profile name and password is valid
-	if profilePassword == profilePasswordInDatabase.ProfilePassword {
-		w.Write([]byte("Login Successful"))
-	} else {
-		w.Write([]byte("Wrong Password"))
+	// Wrong password so don't issue a token
+	matches, needsUpgrade := checkPassword(profilePasswordInDatabase.ProfilePassword, profilePassword)
+	if !matches {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Response{Message: "Wrong Password"})
+		return
+	}
+	// Profiles created before passwords were hashed get their password hashed on their next successful login
+	if needsUpgrade {
+		upgradeLegacyPassword(profileName, profilePassword)
+	}
+
+	// Login is successful both the profile name and password is valid so start a session (access token + refresh token)
+	tokens, err := createSession(profileName)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, "Failed to encode login response", http.StatusInternalServerError)
 	}
 
 }
@@ -279,11 +352,25 @@ func createProfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	if profile.ProfileName == "" || profile.ProfilePassword == "" {
+		http.Error(w, "Profile name and password are required", http.StatusBadRequest)
+		return
+	}
+	// Never store the password itself, only its bcrypt hash
+	passwordHash, err := hashPassword(profile.ProfilePassword)
+	if err == bcrypt.ErrPasswordTooLong {
+		http.Error(w, "Password is too long", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save profile", http.StatusInternalServerError)
+		return
+	}
 	// Insert profile data into MongoDB
 	_, err = collection.InsertOne(context.TODO(), bson.M{
 		"profileName":     profile.ProfileName,
-		"profilePassword": profile.ProfilePassword,
+		"profilePassword": passwordHash,
 		"trophies":        0,
+		"rating":          defaultRating,
 		"status":          "",
 		"country":         "",
 		// Initialize achievements as an empty array
@@ -309,11 +396,12 @@ func updateProfileData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	// Check if the profileName is provided
-	if profile.ProfileName == "" {
-		http.Error(w, "profile name is required", http.StatusBadRequest)
+	// The profile name always comes from the token so users can only update their own profile
+	profileName, ok := authorizedProfileName(w, r, profile.ProfileName)
+	if !ok {
 		return
 	}
+	profile.ProfileName = profileName
 	filter := bson.M{"profileName": profile.ProfileName} // Find by profileName
 	update := bson.M{
 		"$set": bson.M{
@@ -361,9 +449,10 @@ func getLeaderboardData(w http.ResponseWriter, r *http.Request) {
 
 // Getting achievements data
 func getAchievementData(w http.ResponseWriter, r *http.Request) {
-	profileName := r.URL.Query().Get("profileName")
-	if profileName == "" {
-		http.Error(w, "Missing profile name parameter", http.StatusBadRequest)
+	// Defaults to the profile of the token when the profile name parameter is missing
+	profileName, ok := authorizedProfileName(w, r, r.URL.Query().Get("profileName"))
+	if !ok {
+		return
 	}
 	// achievements is a variable to store the document returned from database
 	// If the document has multiple fields it's better to use a struct to map them or if the document has only one field we can simply use simple data type like string , int , etc...
@@ -386,330 +475,431 @@ func getAchievementData(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// Getting History data
-// * This is the perfect handler function kindly change the approach of remaining functions (mongodb operations)
-// * USED PROJECTION
-func getHistoryData(w http.ResponseWriter, r *http.Request) {
-	profileName := r.URL.Query().Get("profileName")
-	if profileName == "" {
-		http.Error(w, "Missing profile name parameter", http.StatusBadRequest)
-	}
-	var history History
-	// Decoding converts the entire BSON document into Go structure
-	// So better use projection so less data is loaded in-memory and transfered from database to server
-	// So after using projection which gets loads necessary fields in-memory we then decode less no of fields compared to not using a projection which will decode the entire document
-	// After getting only the necessary field/fields we decode it to Go structure
-	err := collection.FindOne(context.TODO(), bson.M{"profileName": profileName}, options.FindOne().SetProjection(bson.M{"history": 1, "_id": 0})).Decode(&history)
+// Handling websocket connections
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+	// Verify the token before upgrading so the profile name of the connection can't be spoofed
+	claims, err := parseToken(tokenFromRequest(r))
 	if err != nil {
-		http.Error(w, "Failed to decode history data", http.StatusInternalServerError)
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 		return
 	}
-	// Correctly
-	w.Header().Set("Content-Type", "application/json")
-
-	if err := json.NewEncoder(w).Encode(history); err != nil {
-		http.Error(w, "Failed to encode history data", http.StatusInternalServerError)
+	// Websockets live longer than access tokens so also check that the session was not revoked by a logout
+	if !isSessionActive(claims.Id) {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
 		return
 	}
-
-}
-
-// Handling websocket connections
-func handleConnections(w http.ResponseWriter, r *http.Request) {
 	// Upgrade initial GET request to websocket
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer ws.Close()
-	// Log and echo the message back to the client
+	// Every write goes through the writer goroutine of the connection which also sends the heartbeat pings
+	conn := newConnection(ws)
+	defer conn.Close()
+	// Track the connection so a logout can close it
+	trackConnection(claims.ProfileName, claims.Id, conn)
+	defer untrackConnection(claims.ProfileName, conn)
+	// Free the queue entry and the room of the player when the socket closes so the profile can join again
+	defer leaveRoomOfConnection(claims.ProfileName, conn)
+	defer leaveQueueOfConnection(claims.ProfileName, conn)
+	defer rooms.StopSpectating(conn)
+	defer stopReplay(conn)
 	log.Printf("Client connected!")
-	// Infinite loop to keep reading messages and writing messages back
+	// * The profile name always comes from the verified token, a profile name sent in a message is ignored
+	client := &Client{Connection: conn, ProfileName: claims.ProfileName}
+	// Infinite loop to keep reading the messages of the client, every message is an envelope dispatched to its action
 	for {
-		_, message, err := ws.ReadMessage()
-		// Client disconnects
+		message, err := conn.ReadMessage()
+		// Client disconnects (or missed the heartbeat)
 		if err != nil {
 			log.Println("Client disconnected", err)
 			break
 		}
-		var jsonMessage Message
+		dispatch(client, message)
+	}
+}
 
-		if err := json.Unmarshal(message, &jsonMessage); err != nil {
-			log.Println("Error parsing message:", err)
+// Called for a player who waited longer than the queue timeout, plays against a bot when the bot fallback is enabled
+func queueTimedOut(player PlayerInfo) {
+	if config.Bot.Enabled {
+		startBotMatch(player)
+		return
+	}
+	if err := player.send("queue_timeout", nil); err != nil {
+		log.Printf("Error sending queue timeout to %s\n", player.ProfileName)
+	}
+}
+
+// Creates the room for two matched players and starts the match
+func createMatch(a PlayerInfo, b PlayerInfo) {
+	room, err := rooms.Create(modeDuel, true, a, b)
+	if err != nil {
+		log.Printf("Error creating room for %s and %s: %v\n", a.ProfileName, b.ProfileName, err)
+		// Both players already left the queue, the ones not in a room (the other is usually in a private room from another tab) go back so they are not left waiting for nothing
+		for _, player := range []PlayerInfo{a, b} {
+			if _, inRoom := rooms.RoomOf(player.ProfileName); !inRoom {
+				requeue(player)
+			}
+		}
+		return
+	}
+	startMatch(room)
+}
+
+// Puts a matched player whose room could not be created back in the queue, or tells it to search again
+func requeue(player PlayerInfo) {
+	rating, err := getRating(context.TODO(), player.ProfileName)
+	if err == nil {
+		var opponent *PlayerInfo
+		var position int
+		opponent, position, err = matchmaking.Enqueue(player, rating)
+		if err == nil {
+			if opponent != nil {
+				createMatch(*opponent, player)
+			} else {
+				sendQueuePosition(player, position)
+			}
+			return
 		}
+	}
+	log.Printf("Error putting %s back in the queue: %v\n", player.ProfileName, err)
+	if err := player.send("error", ErrorMessage{Code: errorCodeInternal, Message: "match could not be created, search again"}); err != nil {
+		log.Printf("Error sending match error to %s\n", player.ProfileName)
+	}
+}
+
+// Draws the questions for a full room, tells every player the match is found and starts the question timer
+func startMatch(room *Room) {
+	room.mu.Lock()
+	isTeamMatch := room.Mode == modeTeams
+	room.mu.Unlock()
+	if isTeamMatch {
+		assignTeams(room)
+	}
 
-		// Successfully parsed the json message from client (can be joining or leaving)
-		userPlayerName := jsonMessage.ProfileName
-
-		userAction := jsonMessage.Action
-
-		var matchFound bool = false
-
-		// Ensuring only one goroutine can enter the critical section (match completed) at a time
-		var queueLock sync.Mutex
-		// Incase the action is join check the queue for any empty room if not create one and add the user to the room
-		if userAction == "connect" {
-			// Traverse the queue and find a match for the user
-			for roomId, players := range playersInQueue {
-				// We found a match for the user
-				if len(players) == 1 {
-
-					//* We found a opponent now we have to check if the opponent is equally skilled
-					//trophyDifference := math.Abs(float64(playerTotalTrophies) - float64(playersInQueue[roomId][0].TotalTrophies))
-					// We found a perfect match
-					// ! We dont need skill based matching as of now
-					playersInQueue[roomId] = append(playersInQueue[roomId], PlayerInfo{Connection: ws, ProfileName: userPlayerName})
-					matchFound = true
-
-					// Send confirmation to two users that a match is found
-					for i := 0; i < 2; i++ {
-						if i == 0 {
-							// Send a message to first player in the room that match has been found
-							confirmationMessage := []byte(fmt.Sprintf(`{"message":"Match found!","opponent":"%s","roomId":"%s"}`, playersInQueue[roomId][1].ProfileName, roomId))
-							if err := playersInQueue[roomId][i].Connection.WriteMessage(websocket.TextMessage, confirmationMessage); err != nil {
-								log.Printf("Error sending match confirmation message to user\n")
-							}
-						} else {
-							// Send a message to second player in the room that match has been found
-							confirmationMessage := []byte(fmt.Sprintf(`{"message":"Match found!","opponent":"%s","roomId":"%s"}`, playersInQueue[roomId][0].ProfileName, roomId))
-							if err := playersInQueue[roomId][i].Connection.WriteMessage(websocket.TextMessage, confirmationMessage); err != nil {
-								log.Printf("Error sending match confirmation message to user\n")
-							}
-						}
-
-					}
+	// Draw the questions once for the room so every player is guaranteed to see the same questions
+	questions, err := questionStore.RandomQuestions(context.TODO(), questionsPerMatch)
 
-				}
+	room.mu.Lock()
+	if err != nil {
+		log.Printf("Error drawing questions for room %s: %v", room.ID, err)
+		for _, player := range room.Players {
+			if err := player.send("error", ErrorMessage{Code: "questions_unavailable", Message: "questions could not be loaded"}); err != nil {
+				log.Printf("Error sending question failure message to user\n")
 			}
-			// We didnt found a match all room is filled so create a new room
-			if !matchFound {
-				// Create a Unique roomid for the user because we cannot find any free room for the user
-				roomId, err := generateRandomHex(16)
-				if err != nil {
-					log.Fatalf("Error generating random value: %v", err)
-					return
-				}
-				playersInQueue[roomId] = append(playersInQueue[roomId], PlayerInfo{Connection: ws, ProfileName: userPlayerName})
+		}
+		room.Closed = true
+		room.mu.Unlock()
+		rooms.Complete(room.ID)
+		return
+	}
+	room.Questions = questions
+	room.StartedAt = time.Now()
+	room.MatchId = primitive.NewObjectID()
+	// A rematch keeps the resume tokens of the previous match
+	for i := range room.Players {
+		if room.Players[i].Bot != nil || room.Players[i].ResumeToken != "" {
+			continue
+		}
+		if room.Players[i].ResumeToken, err = generateRandomHex(resumeTokenLength); err != nil {
+			log.Printf("Error creating resume token for %s: %v\n", room.Players[i].ProfileName, err)
+		}
+	}
+
+	playerNames := make([]string, len(room.Players))
+	for i, player := range room.Players {
+		playerNames[i] = player.ProfileName
+	}
+	// Send confirmation to every user that a match is found
+	for _, player := range room.Players {
+		confirmationMessage := MatchFoundMessage{
+			Players:       playerNames,
+			Mode:          room.Mode,
+			RoomId:        room.ID,
+			QuestionCount: len(room.Questions),
+			StartsAt:      room.StartedAt.Add(matchStartDelay).UnixMilli(),
+			Ranked:        room.Ranked,
+			ResumeToken:   player.ResumeToken,
+		}
+		if room.Mode == modeTeams {
+			confirmationMessage.Team = player.Team
+		}
+		for _, other := range room.Players {
+			if other.ProfileName == player.ProfileName {
+				continue
 			}
-		} else if userAction == "disconnect" {
-			// When users rage quits or when the game is finished in both cases completely delete the room and pick your winner
-			for roomId, playerInfo := range playersInQueue {
-				// Make index as _ and player contains array of struct containing user name and websocket address
-				for _, player := range playerInfo {
-					// Remove the room from the queuing server
-					//! I guess the pointer in memory if freed
-					if player.ProfileName == userPlayerName {
-						delete(playersInQueue, roomId)
-					}
-				}
+			if room.Mode == modeDuel {
+				confirmationMessage.Opponent = other.ProfileName
 			}
-		} else if userAction == "player_completed" {
-			// The player finishes all the questions and is used to send the player's total points to the opponent
-			roomId := jsonMessage.RoomId
-			profileName := jsonMessage.ProfileName
-			// Receives as array of points
-
-			playerPoints := jsonMessage.PlayerPoints
-			// Unmarshal: Converts JSON to a Go data structure (e.g., array, slice, struct).
-			// Marshal: Converts a Go data structure to JSON
-
-			// The first player is the one who send the total points to the server
-			if playersInQueue[roomId][0].ProfileName == profileName {
-				// We know have the total points scored by player1 so send the data to player2
-				// * Array of uint16 [0,20,40] -> JSON string [0,20,40]-> Encodede to byte slice (sequence of bytes representing each character in the JSON string using UTF-encoding)
-				// * Byte Slice -> String representation -> Use JSON.parse on that string representation to make use of the data . When you receive  the byte slice in your frontend , the browser automatically converts it into a string representation
-				if err := playersInQueue[roomId][1].Connection.WriteJSON(playerPoints); err != nil {
-					log.Printf("Error sending message to opponent\n")
-				} else {
-					log.Printf("value send is %v ", playerPoints)
-				}
-			} else {
-
-				if err := playersInQueue[roomId][0].Connection.WriteJSON(playerPoints); err != nil {
-					log.Printf("Error sending message to opponent\n")
-				}
+			if room.Mode == modeTeams && other.Team == player.Team {
+				confirmationMessage.Teammates = append(confirmationMessage.Teammates, other.ProfileName)
 			}
-		} else if userAction == "match_completed" {
-			//* Anyone of the two players winner or loser can hit the server (only one) , only after the match is completed we know who is winner and who is not
-			// Locking this section so that only one goroutine can come here at a time
-			// If the lock is in use then the goroutine waits until the mutex is available
-			// Once the lock is called , the goroutine gains exclusive access to the critical ssection , other goroutines trying to call the lock will wait until the lock is released
-			queueLock.Lock()
-			// The lock is released when the current goroutine exits the function or completes its processing even if an error occurs or the function returns early
-			defer queueLock.Unlock()
-			roomId := jsonMessage.RoomId
-			playerPoints := jsonMessage.PlayerPoints
-			opponentName := jsonMessage.OpponentName
-			opponentTotalPoints := jsonMessage.OpponentPoints
-
-			// * Calculated from frontend
-			isPerfectScore := jsonMessage.IsPerfectScore
-
-			// * Calculated from frontend
-			isLightingReflexesCompleted := jsonMessage.IsLightingReflexesCompleted
-
-			// * Calculated from backend
-			// Check if the winner is behind by 40 or more points
-			var winner, clutchPerformer string
-			totalPoint := playerPoints[len(playerPoints)-1]
-			opponentTotalPoint := opponentTotalPoints[len(opponentTotalPoints)-1]
-
-			if totalPoint > opponentTotalPoint {
-				winner = userPlayerName
-			} else if opponentTotalPoint > totalPoint {
-				winner = opponentName
+			if other.Bot != nil {
+				confirmationMessage.OpponentIsBot = true
 			}
+		}
+		if err := player.send("match_found", confirmationMessage); err != nil {
+			log.Printf("Error sending match confirmation message to user\n")
+		}
+	}
+	// Spectators still watching after a rematch get the state of the new match
+	room.sendToSpectators("spectating", room.spectateMessage())
+	room.mu.Unlock()
 
-			for i := 0; i < 5; i++ {
-				// Opponent is behind by 40 points or more and then won the match
-				if playerPoints[i]-opponentTotalPoints[i] > 40 && winner == opponentName {
-					clutchPerformer = opponentName
-					break
-				}
-				// Player is behind by 40 points or more and then won the match
-				if opponentTotalPoints[i]-playerPoints[i] > 40 && winner == userPlayerName {
-					clutchPerformer = userPlayerName
-					break
-				}
-			}
+	// The server timer sends the questions and scores the match
+	go runQuestionTimer(room)
+}
 
-			_, exits := playersInQueue[roomId]
-			if exits {
-				if totalPoint > opponentTotalPoint {
-					updateAchievementData(userPlayerName, isPerfectScore, opponentName, false, isLightingReflexesCompleted, clutchPerformer, w)
-				} else if totalPoint < opponentTotalPoint {
-					updateAchievementData(opponentName, isPerfectScore, userPlayerName, false, isLightingReflexesCompleted, clutchPerformer, w)
-				} else {
-					updateAchievementData(userPlayerName, isPerfectScore, opponentName, true, isLightingReflexesCompleted, clutchPerformer, w)
-				}
-				delete(playersInQueue, roomId)
-			}
+// Removes the room of the player and stops its question timer
+// Leaving a match in progress forfeits it, in a free-for-all room only the player leaves while enough players are left to go on
+func leaveRoom(profileName string) {
+	if room, inRoom := rooms.RoomOf(profileName); inRoom && forfeitMatch(room, profileName) {
+		return
+	}
+	if _, removed := rooms.RemovePlayer(profileName); removed {
+		return
+	}
+	room, inRoom := rooms.Leave(profileName)
+	if !inRoom {
+		return
+	}
+	room.mu.Lock()
+	room.Closed = true
+	room.mu.Unlock()
+}
+
+// Leaves the room of the player when the closed socket is the one playing in the room
+// Another tab of the same profile may have its own socket which must not lose its room
+// During a match the seat is held for the resume grace period instead
+func leaveRoomOfConnection(profileName string, conn *Connection) {
+	room, inRoom := rooms.RoomOf(profileName)
+	if !inRoom {
+		return
+	}
+	if holdSeat(room, profileName, conn) {
+		return
+	}
+	room.mu.Lock()
+	player := room.player(profileName)
+	isThisConnection := player != nil && player.Connection == conn
+	room.mu.Unlock()
+	if isThisConnection {
+		leaveRoom(profileName)
+	}
+}
+
+// Takes the player out of the matchmaking queue and the free-for-all lobby when the closed socket is the one waiting there
+func leaveQueueOfConnection(profileName string, conn *Connection) {
+	if matchmaking.RemoveConnection(profileName, conn) {
+		notifyQueuePositions()
+	}
+	for _, lobby := range []*GroupLobby{ffaLobby, teamLobby} {
+		if lobby.RemoveConnection(profileName, conn) {
+			lobby.notifyPositions()
 		}
 	}
 }
 
-func updateAchievementData(winner string, isPerfectScore bool, loser string, isMatchDrawn bool, isLightingReflexesCompleted bool, clutchPerformer string, w http.ResponseWriter) {
+// Takes the player out of the matchmaking queue and the free-for-all lobby, returns false when the player was not waiting
+func leaveQueue(profileName string) bool {
+	_, queued := matchmaking.Remove(profileName)
+	if queued {
+		notifyQueuePositions()
+	}
+	for _, lobby := range []*GroupLobby{ffaLobby, teamLobby} {
+		if lobby.Remove(profileName) {
+			lobby.notifyPositions()
+			queued = true
+		}
+	}
+	return queued
+}
 
-	// IsPerfectScore and IsLightingReflexesCompleted can happen with any player losing or winning player
+// Takes the socket out of the queues of the other modes when the player starts searching in the given mode
+func leaveOtherQueues(profileName string, conn *Connection, mode string) {
+	lobby, _ := groupLobby(mode)
+	if lobby != nil && matchmaking.RemoveConnection(profileName, conn) {
+		notifyQueuePositions()
+	}
+	for _, other := range []*GroupLobby{ffaLobby, teamLobby} {
+		if other != lobby && other.RemoveConnection(profileName, conn) {
+			other.notifyPositions()
+		}
+	}
+}
+
+// Returns the lobby of the mode and the function starting the match of a full group, nil for duels
+func groupLobby(mode string) (*GroupLobby, func(players []PlayerInfo)) {
+	switch mode {
+	case modeFreeForAll:
+		return ffaLobby, createFreeForAllMatch
+	case modeTeams:
+		return teamLobby, createTeamMatch
+	}
+	return nil, nil
+}
+
+// Records the answer of the player and sends the result back, the points of the other players are sent by the timer at question_end
+// Returns the reason when the answer is rejected
+func submitAnswer(room *Room, profileName string, questionId string, selectedOption int, clientTimestamp int64) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
 
-	var trophiesGained, trophiesLost int16
+	answer, err := room.recordAnswer(profileName, questionId, selectedOption, clientTimestamp)
+	if err != nil {
+		return err
+	}
+	player := room.player(profileName)
+	if err := player.send("answer_result", AnswerResultMessage{
+		QuestionId: answer.QuestionId,
+		IsCorrect:  answer.IsCorrect,
+		Points:     answer.Points,
+	}); err != nil {
+		log.Printf("Error sending answer result to %s\n", profileName)
+	}
+	// Spectators only learn that the player answered, the answer itself is revealed at question_end
+	room.sendToSpectators("player_answered", PlayerAnsweredMessage{
+		ProfileName:   profileName,
+		QuestionIndex: room.CurrentQuestion,
+		TimeTaken:     answer.TimeTaken.Milliseconds(),
+	})
+	return nil
+}
 
-	// Updating Trophies based on match result
-	if isMatchDrawn {
-		trophiesGained = 0
-		trophiesLost = 0
-	} else {
-		trophiesGained = 5
-		trophiesLost = -3
+// Scores the match from the recorded answers and updates trophies and achievements
+// Returns true when the match was completed so the caller can remove the room (after releasing the room lock)
+// The caller must hold the room lock
+func completeMatchIfFinished(room *Room) bool {
+	if room.Completed || !room.allFinished() {
+		return false
+	}
+	room.Completed = true
+
+	result := computeMatchResult(room)
+	updateAchievementData(result)
+	go saveMatchRecord(room.matchRecord(result))
+
+	resultMessage := MatchResultMessage{
+		Winner:    result.Winner,
+		IsDrawn:   result.IsDrawn,
+		Points:    make(map[string][]uint16),
+		Standings: result.Standings,
+		// Both are only set in team matches
+		WinningTeam: result.WinningTeam,
+		TeamPoints:  result.TeamPoints,
+	}
+	for i := range room.Players {
+		resultMessage.Points[room.Players[i].ProfileName] = pointsProgression(&room.Players[i])
 	}
+	room.broadcast("match_result", resultMessage)
+	return true
+}
 
-	// Immeditaley send the response before starting go routines (not get hijacked)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Trophy update request is being processed..."))
+func updateAchievementData(result MatchResult) {
 
-	// Go routines is called immediately which creates a new gorountine and runs mongodb operation in the background without blocking the main execution thread
-	go func() {
-		filter := bson.M{"profileName": winner}
-		update := bson.M{
-			"$inc": bson.M{
-				"trophies": trophiesGained,
-			},
-		}
+	// Perfect Score and Lightning Reflexes can happen with any player losing or winning player
 
-		if !isMatchDrawn {
-			// Increment Win counter
-			update["$inc"] = bson.M{
-				"achievements.0": 1,
+	// Ratings of every player are updated together in one transaction
+	// A player leaving a free-for-all match early loses its trophies right away and its rating once the match of the others ends, ranked behind everyone who finished
+	if result.Ranked && len(result.StillPlaying) == 0 {
+		go updateRatings(result)
+	}
+
+	for _, standing := range result.Standings {
+		// Go routines is called immediately which creates a new gorountine and runs mongodb operation in the background without blocking the main execution thread
+		go func(standing Standing) {
+			// Bots have no profile to update and players still playing get their result when their match ends
+			if result.Bots[standing.ProfileName] || result.StillPlaying[standing.ProfileName] {
+				return
 			}
-		}
-		// Answered everything right
-		if isPerfectScore {
-			update["$set"] = bson.M{
-				"achievements.1": true,
+			filter := bson.M{"profileName": standing.ProfileName}
+			// Trophies are only set in ranked matches which are not drawn
+			inc := bson.M{"trophies": standing.Trophies}
+			set := achievementsUnlocked(result, standing.ProfileName)
+
+			matchResult := result.outcome(standing)
+
+			if matchResult == "Won" && result.Ranked {
+				// Increment Win counter
+				inc["achievements.0"] = 1
 			}
-		}
-		// Answered correctly within 3 seconds
-		if isLightingReflexesCompleted {
-			update["$set"] = bson.M{
-				"achievements.2": true,
+			// Clutch Performer can only be the winner
+			if result.ClutchPerformer == standing.ProfileName && result.Ranked {
+				set["achievements.4"] = true
 			}
-		}
-		// Record the result in history
-		// DRAW
-		if isMatchDrawn {
-			update["$push"] = bson.M{
-				"history": bson.M{
-					"opponent": loser,
-					"result":   "Draw",
-				},
+			update := bson.M{"$inc": inc}
+			if len(set) > 0 {
+				update["$set"] = set
 			}
-		} else {
-			// WON
-			update["$push"] = bson.M{
-				"history": bson.M{
-					"opponent": loser,
-					"result":   "Won",
-				},
+
+			// Record the result in history
+			historyItem := bson.M{
+				"result":      matchResult,
+				"matchId":     result.MatchId.Hex(),
+				"playedAt":    time.Now(),
+				"score":       standing.Points,
+				"trophyDelta": standing.Trophies,
 			}
-		}
-		// Clutch Performer can always be the winner if he is coming from a draw here dont accept
-		if clutchPerformer != "" && !isMatchDrawn {
-			update["$set"] = bson.M{
-				"achievements.4": true,
+			var teammates, opponents []string
+			for _, other := range result.Standings {
+				if other.ProfileName == standing.ProfileName {
+					continue
+				}
+				if result.Mode == modeTeams && other.Team == standing.Team {
+					teammates = append(teammates, other.ProfileName)
+				} else {
+					opponents = append(opponents, other.ProfileName)
+				}
 			}
-		}
-		_, err := collection.UpdateOne(context.TODO(), filter, update)
-		if err != nil {
-			fmt.Printf("Error when updating trophies")
-		}
-	}()
-
-	go func() {
-		filter := bson.M{"profileName": loser}
-		update := bson.M{
-			"$inc": bson.M{
-				"trophies": trophiesLost,
-			},
-		}
-		// Answered everything right
-		if isPerfectScore {
-			update["$set"] = bson.M{
-				"achievements.1": true,
+			switch result.Mode {
+			case modeDuel:
+				historyItem["opponent"] = opponents[0]
+			case modeTeams:
+				historyItem["mode"] = result.Mode
+				historyItem["teammates"] = teammates
+				historyItem["opponents"] = opponents
+			default:
+				historyItem["mode"] = result.Mode
+				historyItem["place"] = standing.Place
+				historyItem["opponents"] = opponents
 			}
-		}
-		// Answered correctly within 3 seconds
-		if isLightingReflexesCompleted {
-			update["$set"] = bson.M{
-				"achievements.2": true,
+			push := bson.M{"history": historyItem}
+			if result.ForfeitedBy != "" {
+				// Recorded in the history of every player of the match
+				historyItem["forfeitedBy"] = result.ForfeitedBy
+				// Abandoned ranked matches count towards the queue cooldown
+				if standing.ProfileName == result.ForfeitedBy && result.Ranked {
+					push["abandonments"] = bson.M{"$each": []time.Time{time.Now()}, "$slice": -abandonmentHistoryLength}
+				}
 			}
-		}
+			update["$push"] = push
 
-		// Record the result in history
-		// DRAW
-		if isMatchDrawn {
-			update["$push"] = bson.M{
-				"history": bson.M{
-					"opponent": winner,
-					"result":   "Draw",
-				},
+			_, err := collection.UpdateOne(context.TODO(), filter, update)
+			if err != nil {
+				fmt.Printf("Error when updating trophies")
 			}
-		} else {
-			// LOST
-			update["$push"] = bson.M{
-				"history": bson.M{
-					"opponent": winner,
-					"result":   "Lost",
-				},
-			}
-		}
+		}(standing)
+	}
 
-		_, err := collection.UpdateOne(context.TODO(), filter, update)
-		if err != nil {
-			fmt.Printf("Error when updating trophies")
-		}
-	}()
+}
 
+// Achievements the player unlocked in this match which can be unlocked by both the winner and the loser
+// Achievements are only unlocked in ranked matches
+func achievementsUnlocked(result MatchResult, profileName string) bson.M {
+	set := bson.M{}
+	if !result.Ranked {
+		return set
+	}
+	// Answered everything right
+	if result.PerfectScore[profileName] {
+		set["achievements.1"] = true
+	}
+	// Answered correctly within 3 seconds
+	if result.LightningReflexes[profileName] {
+		set["achievements.2"] = true
+	}
+	return set
 }
 
 func updateProfileImage(w http.ResponseWriter, r *http.Request) {
@@ -721,19 +911,20 @@ func updateProfileImage(w http.ResponseWriter, r *http.Request) {
 	}
 	// Retreiving the file
 	file, _, err := r.FormFile("profileImage")
-	// Retreiving the profile name
-	profileName := r.FormValue("profileName")
 	if err != nil {
 		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
 		return
 	}
+	// Retreiving the profile name (must be the same as the profile of the token)
+	profileName, ok := authorizedProfileName(w, r, r.FormValue("profileName"))
+	if !ok {
+		file.Close()
+		return
+	}
 	// Releases file handle where file handle will consume system resource (file descriptors - used to read,write or manage file without directly manipulating the underlying data structures in the OS)
 	defer file.Close()
-	cloudName := os.Getenv("CLOUDINARY_CLOUD_NAME")
-	cloudAPIKey := os.Getenv("CLOUDINARY_API_KEY")
-	cloudSecret := os.Getenv("CLOUDINARY_API_SECRET")
 	// Initializing cloudinary instance
-	cld, err := cloudinary.NewFromParams(cloudName, cloudAPIKey, cloudSecret)
+	cld, err := cloudinary.NewFromParams(config.CloudinaryCloudName, config.CloudinaryAPIKey, config.CloudinaryAPISecret)
 	if err != nil {
 		fmt.Print("Failed to initialize cloudinary")
 		return
@@ -771,14 +962,31 @@ func generateRandomHex(length int) (string, error) {
 
 func main() {
 
+	// Load the config from the .env file, environment variables and flags
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	config = cfg
+
 	// Connect to MongoDB
-	connectMongoDB()
+	connectMongoDB(config)
+
+	// Matchmaking queue widening the rating window of waiting players in the background
+	matchmaking = NewMatchmakingQueue(config.Matchmaking)
+	go matchmaking.Run(createMatch, queueTimedOut)
+	// Free-for-all and team lobbies starting the matches of waiting groups in the background
+	ffaLobby = NewGroupLobby(config.FreeForAll.MinPlayers, config.FreeForAll.MaxPlayers, config.FreeForAll.StartAfter, config.Matchmaking.QueueTimeout)
+	go ffaLobby.Run(createFreeForAllMatch, ffaLobbyTimedOut)
+	// Team matches always start with four players
+	teamLobby = NewGroupLobby(teamRoomPlayers, teamRoomPlayers, 0, config.Matchmaking.QueueTimeout)
+	go teamLobby.Run(createTeamMatch, teamLobbyTimedOut)
 	mux := http.NewServeMux()
 	// Configure CORS to allow requests from your frontend
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:5173"},
+		AllowedOrigins:   config.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
 	// Setting up CORS middleware
@@ -787,6 +995,7 @@ func main() {
 
 	// Websocket connection
 	// Dont add rate limiting middleware for websockets
+	// The token is verified inside the handler before the upgrade (sent as the token query parameter)
 	mux.HandleFunc("/ws", handleConnections)
 	// Setting HTTP endpoint for saving profile data
 	// First the CORS Middleware , Rate limiting Middleware then the handler function
@@ -794,19 +1003,30 @@ func main() {
 	// Setting HTTP endpoint for checking profile name (used to check if the username exists or not while creating account and when during login auth)
 	mux.Handle("/check-profile", rateLimitMiddleware(http.HandlerFunc(checkProfileNameExists)))
 	// Updating profile data
-	mux.Handle("/update-profile-data", rateLimitMiddleware(http.HandlerFunc(updateProfileData)))
+	// Endpoints below the login need a valid token (Rate limiting Middleware , Auth Middleware then the handler function)
+	mux.Handle("/update-profile-data", rateLimitMiddleware(authMiddleware(http.HandlerFunc(updateProfileData))))
 	// Getting leaderboard data
 	mux.Handle("/leaderboard-data", rateLimitMiddleware(http.HandlerFunc(getLeaderboardData)))
+	// Getting leaderboard data sorted by rating
+	mux.Handle("/rating-leaderboard-data", rateLimitMiddleware(http.HandlerFunc(getRatingLeaderboardData)))
 	// For getting achievement data of a user
-	mux.Handle("/get-achievement-data", rateLimitMiddleware(http.HandlerFunc(getAchievementData)))
+	mux.Handle("/get-achievement-data", rateLimitMiddleware(authMiddleware(http.HandlerFunc(getAchievementData))))
 	// For getting history data of a user
-	mux.Handle("/get-history-data", rateLimitMiddleware(http.HandlerFunc(getHistoryData)))
+	mux.Handle("/get-history-data", rateLimitMiddleware(authMiddleware(http.HandlerFunc(getHistoryData))))
+	// Getting the record of a match to review it (every question, answer and points progression)
+	mux.Handle("/matches/{id}", rateLimitMiddleware(authMiddleware(http.HandlerFunc(getMatch))))
+	// Getting the record of two players against each other
+	mux.Handle("/head-to-head", rateLimitMiddleware(authMiddleware(http.HandlerFunc(getHeadToHead))))
 	// Run this function to store profile image in cloudinary
-	mux.Handle("/update-profile-image", rateLimitMiddleware(http.HandlerFunc(updateProfileImage)))
-
-	// Start the server on port 5000
-	fmt.Println("Websocket server started on port 5000")
-	err := http.ListenAndServe(":5000", handler)
+	mux.Handle("/update-profile-image", rateLimitMiddleware(authMiddleware(http.HandlerFunc(updateProfileImage))))
+	// Rotating the refresh token to get a new access token
+	mux.Handle("/refresh", rateLimitMiddleware(http.HandlerFunc(refreshSession)))
+	// Revoking the session and closing its websockets
+	mux.Handle("/logout", rateLimitMiddleware(authMiddleware(http.HandlerFunc(logout))))
+
+	// Start the server on the configured port
+	fmt.Printf("Websocket server started on port %s\n", config.Port)
+	err = http.ListenAndServe(":"+config.Port, handler)
 	if err != nil {
 		log.Fatal("ListenAndServe error:", err)
 	}
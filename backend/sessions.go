@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How long a refresh token stays valid, every refresh issues a new one with a new expiry
+const refreshTokenExpiry = 30 * 24 * time.Hour
+
+// Websocket close code sent when the session of the connection is revoked (4000-4999 are free for applications)
+const closeSessionRevoked = 4001
+
+// Sessions collection holding the refresh tokens
+var sessionsCollection *mongo.Collection
+
+// Session struct to hold a login session, only the hash of the refresh token is stored so a leaked database can't be used to log in
+type Session struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty"`
+	ProfileName      string             `bson:"profileName"`
+	RefreshTokenHash string             `bson:"refreshTokenHash"`
+	CreatedAt        time.Time          `bson:"createdAt"`
+	ExpiresAt        time.Time          `bson:"expiresAt"`
+	Revoked          bool               `bson:"revoked"`
+}
+
+// Request body of /refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Creates the indexes of the sessions collection
+// Lookups happen by the hash of the refresh token and MongoDB removes sessions on its own once they expire (TTL index)
+func ensureSessionIndexes(ctx context.Context) {
+	_, err := sessionsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"refreshTokenHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"expiresAt": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		log.Printf("Error creating session indexes: %v", err)
+	}
+}
+
+// Hashes the refresh token before it is stored or looked up
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates a new session for the profile and returns the access token and the refresh token
+func createSession(profileName string) (LoginResponse, error) {
+	refreshToken, err := generateRandomHex(64)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	now := time.Now()
+	session := Session{
+		ID:               primitive.NewObjectID(),
+		ProfileName:      profileName,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(refreshTokenExpiry),
+	}
+	if _, err := sessionsCollection.InsertOne(context.TODO(), session); err != nil {
+		return LoginResponse{}, err
+	}
+	return sessionTokens(session, refreshToken)
+}
+
+// Signs an access token for the session and puts it together with the refresh token in the response
+func sessionTokens(session Session, refreshToken string) (LoginResponse, error) {
+	token, expiresAt, err := generateToken(session.ProfileName, session.ID.Hex())
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	return LoginResponse{
+		Message:          "Login Successful",
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+
+// Checks that the session of an access token was not revoked (used where a stateless token check is not enough like the websocket upgrade)
+func isSessionActive(sessionId string) bool {
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil {
+		return false
+	}
+	filter := bson.M{"_id": id, "revoked": false, "expiresAt": bson.M{"$gt": time.Now()}}
+	err = sessionsCollection.FindOne(context.TODO(), filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	return err == nil
+}
+
+// Rotates the refresh token, the old refresh token stops working and a new access token and refresh token are returned
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	var request RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	newRefreshToken, err := generateRandomHex(64)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	// Finding and replacing the hash in a single operation so the same refresh token can't be used twice by two concurrent requests
+	filter := bson.M{
+		"refreshTokenHash": hashRefreshToken(request.RefreshToken),
+		"revoked":          false,
+		"expiresAt":        bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{
+		"refreshTokenHash": hashRefreshToken(newRefreshToken),
+		"expiresAt":        now.Add(refreshTokenExpiry),
+	}}
+	var session Session
+	err = sessionsCollection.FindOneAndUpdate(context.TODO(), filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := sessionTokens(session, newRefreshToken)
+	if err != nil {
+		http.Error(w, "Failed to create session token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, "Failed to encode session tokens", http.StatusInternalServerError)
+	}
+}
+
+// Revokes the session of the access token (or every session of the profile with ?all=true) and closes its websockets
+func logout(w http.ResponseWriter, r *http.Request) {
+	profileName := profileNameFromContext(r.Context())
+	sessionId := sessionIdFromContext(r.Context())
+
+	filter := bson.M{"profileName": profileName, "revoked": false}
+	revokeAll := r.URL.Query().Get("all") == "true"
+	if !revokeAll {
+		id, err := primitive.ObjectIDFromHex(sessionId)
+		if err != nil {
+			http.Error(w, "Token has no session", http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = id
+	}
+	update := bson.M{"$set": bson.M{"revoked": true, "revokedAt": time.Now()}}
+	if _, err := sessionsCollection.UpdateMany(context.TODO(), filter, update); err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	if revokeAll {
+		closeProfileConnections(profileName, "")
+	} else {
+		closeProfileConnections(profileName, sessionId)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Message: "Logged out"})
+}
+
+var (
+	// Open websocket connections of every profile along with the session the connection was opened with
+	profileConnections = make(map[string]map[*Connection]string)
+	connectionsMu      sync.Mutex
+)
+
+// Remembers the websocket connection so it can be closed when its session is revoked
+func trackConnection(profileName string, sessionId string, conn *Connection) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	if profileConnections[profileName] == nil {
+		profileConnections[profileName] = make(map[*Connection]string)
+	}
+	profileConnections[profileName][conn] = sessionId
+}
+
+// Forgets the websocket connection once the client disconnects
+func untrackConnection(profileName string, conn *Connection) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	delete(profileConnections[profileName], conn)
+	if len(profileConnections[profileName]) == 0 {
+		delete(profileConnections, profileName)
+	}
+}
+
+// Closes the websockets of the profile opened with the given session (every websocket of the profile when sessionId is empty)
+// Closing the connection makes the read in handleConnections fail so the handler cleans up on its own
+func closeProfileConnections(profileName string, sessionId string) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	for conn, connectionSessionId := range profileConnections[profileName] {
+		if sessionId != "" && connectionSessionId != sessionId {
+			continue
+		}
+		// The writer goroutine sends the close frame and closes the socket
+		conn.CloseWithReason(closeSessionRevoked, "session revoked")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Most spectators one room accepts
+const maxSpectators = 50
+
+var (
+	errRoomNotFound      = errors.New("room does not exist")
+	errSpectatingOwnRoom = errors.New("players can't spectate their own room")
+	errTooManySpectators = errors.New("room has too many spectators")
+)
+
+// Sent to a spectator once it is watching the room with everything needed to show the match so far
+type SpectateMessage struct {
+	RoomId        string     `json:"roomId"`
+	Mode          string     `json:"mode"`
+	Players       []string   `json:"players"`
+	Standings     []Standing `json:"standings"`
+	QuestionCount int        `json:"questionCount"`
+	// Index of the running question (-1 before the first question) with its deadline in unix milliseconds
+	QuestionIndex int       `json:"questionIndex"`
+	Question      *Question `json:"question,omitempty"`
+	Deadline      int64     `json:"deadline,omitempty"`
+	Completed     bool      `json:"completed"`
+	Spectators    int       `json:"spectators"`
+}
+
+// Sent to the spectators when a player answers, the selected option and whether it is correct are only revealed by question_end
+type PlayerAnsweredMessage struct {
+	ProfileName   string `json:"profileName"`
+	QuestionIndex int    `json:"questionIndex"`
+	// Time the player took to answer in milliseconds
+	TimeTaken int64 `json:"timeTaken"`
+}
+
+// Spectate adds the connection as a read-only spectator of the room and sends it the state of the match so far
+// A connection spectates one room at a time so it leaves the room it was watching before
+func (m *RoomManager) Spectate(roomId string, spectator PlayerInfo) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, exists := m.rooms[roomId]
+	if !exists {
+		return nil, errRoomNotFound
+	}
+	if m.profileRooms[spectator.ProfileName] == roomId {
+		return nil, errSpectatingOwnRoom
+	}
+	if previousRoomId, spectating := m.spectating[spectator.Connection]; spectating {
+		if previousRoom, exists := m.rooms[previousRoomId]; exists {
+			previousRoom.mu.Lock()
+			previousRoom.removeSpectator(spectator.Connection)
+			previousRoom.mu.Unlock()
+		}
+		delete(m.spectating, spectator.Connection)
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.Closed {
+		return nil, errRoomNotFound
+	}
+	if len(room.Spectators) >= maxSpectators {
+		return nil, errTooManySpectators
+	}
+	room.Spectators = append(room.Spectators, spectator)
+	m.spectating[spectator.Connection] = roomId
+	// Sent while holding the room lock so the spectator never gets an event of the room before its state
+	if err := spectator.send("spectating", room.spectateMessage()); err != nil {
+		log.Printf("Error sending room state to spectator %s\n", spectator.ProfileName)
+	}
+	return room, nil
+}
+
+// StopSpectating removes the connection from the room it is watching
+func (m *RoomManager) StopSpectating(conn *Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roomId, spectating := m.spectating[conn]
+	if !spectating {
+		return
+	}
+	delete(m.spectating, conn)
+	if room, exists := m.rooms[roomId]; exists {
+		room.mu.Lock()
+		room.removeSpectator(conn)
+		room.mu.Unlock()
+	}
+}
+
+// Removes the spectator watching with the connection
+// The caller must hold the room lock
+func (room *Room) removeSpectator(conn *Connection) {
+	for i := range room.Spectators {
+		if room.Spectators[i].Connection == conn {
+			room.Spectators = append(room.Spectators[:i], room.Spectators[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sends the message to every spectator of the room
+// The caller must hold the room lock
+func (room *Room) sendToSpectators(messageType string, payload interface{}) {
+	for _, spectator := range room.Spectators {
+		if err := spectator.send(messageType, payload); err != nil {
+			log.Printf("Error sending to spectator %s\n", spectator.ProfileName)
+		}
+	}
+}
+
+// Sends the message to every player and every spectator of the room
+// The caller must hold the room lock
+func (room *Room) broadcast(messageType string, payload interface{}) {
+	for _, player := range room.Players {
+		if err := player.send(messageType, payload); err != nil {
+			log.Printf("Error sending %s to %s\n", messageType, player.ProfileName)
+		}
+	}
+	room.sendToSpectators(messageType, payload)
+}
+
+// Builds the state of the room for a new spectator
+// The caller must hold the room lock
+func (room *Room) spectateMessage() SpectateMessage {
+	message := SpectateMessage{
+		RoomId:        room.ID,
+		Mode:          room.Mode,
+		Standings:     room.standings(),
+		QuestionCount: len(room.Questions),
+		QuestionIndex: room.CurrentQuestion,
+		Completed:     room.Completed,
+		Spectators:    len(room.Spectators),
+	}
+	for _, player := range room.Players {
+		message.Players = append(message.Players, player.ProfileName)
+	}
+	// The running question is only sent while it can still be answered
+	if room.CurrentQuestion >= 0 && !room.Completed && time.Now().Before(room.QuestionDeadline) {
+		question := room.Questions[room.CurrentQuestion]
+		message.Question = &question
+		message.Deadline = room.QuestionDeadline.UnixMilli()
+	}
+	return message
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Two teams of two players
+	teamRoomPlayers = 4
+	// Longest team chat message in characters
+	maxTeamChatLength = 200
+)
+
+var (
+	errNotTeamRoom   = errors.New("room is not a team room")
+	errEmptyTeamChat = errors.New("team chat message is empty")
+)
+
+// Sent to every member of the team for a team_chat message, the other team never sees it
+type TeamChatMessage struct {
+	RoomId string `json:"roomId"`
+	From   string `json:"from"`
+	Text   string `json:"text"`
+	// Unix milliseconds when the server received the message
+	SentAt int64 `json:"sentAt"`
+}
+
+// Team lobby used by the websocket handlers, created in main
+var teamLobby *GroupLobby
+
+// Creates the room for four players from the team lobby and starts the match
+func createTeamMatch(players []PlayerInfo) {
+	room, err := rooms.Create(modeTeams, true, players...)
+	if err != nil {
+		log.Printf("Error creating team room for %d players: %v\n", len(players), err)
+		return
+	}
+	startMatch(room)
+}
+
+// Called for the players who waited in the team lobby longer than the queue timeout
+// With the bot fallback they play an unranked team match with bots filling the missing places, otherwise they get queue_timeout
+func teamLobbyTimedOut(players []PlayerInfo) {
+	if !config.Bot.Enabled {
+		sendQueueTimeout(players)
+		return
+	}
+	players, err := fillWithBots(players, teamRoomPlayers)
+	if err != nil {
+		log.Printf("Error creating bot for team room: %v\n", err)
+		return
+	}
+	room, err := rooms.Create(modeTeams, false, players...)
+	if err != nil {
+		log.Printf("Error creating team bot room: %v\n", err)
+		return
+	}
+	startMatch(room)
+}
+
+// Splits the players of a team room into two teams with about the same rating
+// The best and the worst rated player play together against the two players in between
+func assignTeams(room *Room) {
+	room.mu.Lock()
+	var profileNames []string
+	for _, player := range room.Players {
+		if player.Bot == nil {
+			profileNames = append(profileNames, player.ProfileName)
+		}
+	}
+	room.mu.Unlock()
+
+	// Bots and players whose rating can't be read count with the default rating
+	ratings := make(map[string]int)
+	for _, profileName := range profileNames {
+		rating, err := getRating(context.TODO(), profileName)
+		if err != nil {
+			log.Printf("Error getting rating of %s: %v\n", profileName, err)
+			continue
+		}
+		ratings[profileName] = rating
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	order := make([]int, len(room.Players))
+	for i := range order {
+		order[i] = i
+	}
+	rating := func(i int) int {
+		if r, ok := ratings[room.Players[i].ProfileName]; ok {
+			return r
+		}
+		return defaultRating
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rating(order[a]) > rating(order[b])
+	})
+	for position, i := range order {
+		if position == 0 || position == len(order)-1 {
+			room.Players[i].Team = 1
+		} else {
+			room.Players[i].Team = 2
+		}
+	}
+}
+
+// Points of every team, the sum of the points of its members
+// The caller must hold the room lock
+func (room *Room) teamPoints() map[int]uint16 {
+	points := make(map[int]uint16)
+	for _, player := range room.Players {
+		points[player.Team] += player.PlayerPoints
+	}
+	return points
+}
+
+// Sends the chat message of the player to every member of its team
+func sendTeamChat(room *Room, profileName string, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errEmptyTeamChat
+	}
+	if runes := []rune(text); len(runes) > maxTeamChatLength {
+		text = string(runes[:maxTeamChatLength])
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.Mode != modeTeams {
+		return errNotTeamRoom
+	}
+	sender := room.player(profileName)
+	if sender == nil {
+		return errPlayerNotInRoom
+	}
+	chatMessage := TeamChatMessage{
+		RoomId: room.ID,
+		From:   profileName,
+		Text:   text,
+		SentAt: time.Now().UnixMilli(),
+	}
+	// The sender gets the message too so every member sees the chat in the same order
+	for _, player := range room.Players {
+		if player.Team != sender.Team {
+			continue
+		}
+		if err := player.send("team_chat", chatMessage); err != nil {
+			log.Printf("Error sending team chat to %s\n", player.ProfileName)
+		}
+	}
+	return nil
+}
+
+// Rating changes of every player after a team match, both teams play with the average rating of their members
+// Every member of a team gets the same change
+func teamRatingDeltas(ratings []int, teams []int, winningTeam int) []int {
+	sums := make(map[int]int)
+	counts := make(map[int]int)
+	for i, team := range teams {
+		sums[team] += ratings[i]
+		counts[team]++
+	}
+	average := func(team int) int {
+		if counts[team] == 0 {
+			return defaultRating
+		}
+		return sums[team] / counts[team]
+	}
+
+	deltas := make([]int, len(ratings))
+	for i, team := range teams {
+		// Teams are numbered 1 and 2
+		otherTeam := 3 - team
+		score := 0.5
+		if winningTeam == team {
+			score = 1
+		} else if winningTeam == otherTeam {
+			score = 0
+		}
+		deltas[i] = int(math.Round(eloKFactor * (score - expectedScore(average(team), average(otherTeam)))))
+	}
+	return deltas
+}
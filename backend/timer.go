@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// Time given to answer every question
+	questionDuration = 5 * time.Second
+	// Time between match_found and the first question so both clients can open the room
+	matchStartDelay = 3 * time.Second
+)
+
+// Sent to every player and spectator when a question starts, the question never contains the correct option
+type QuestionStartMessage struct {
+	QuestionIndex int      `json:"questionIndex"`
+	Question      Question `json:"question"`
+	// Deadline in unix milliseconds, answers received after the deadline are rejected
+	Deadline int64 `json:"deadline"`
+	Duration int64 `json:"duration"`
+}
+
+// Sent to every player and spectator when the time for a question is over (or everyone answered)
+type QuestionEndMessage struct {
+	QuestionIndex int               `json:"questionIndex"`
+	QuestionId    string            `json:"questionId"`
+	CorrectOption int               `json:"correctOption"`
+	Points        map[string]uint16 `json:"points"`
+}
+
+// Runs the questions of the room one by one, the server decides when a question starts and ends so every player moves in lockstep
+// Runs in its own goroutine for every room and exits once the match is scored or the room is closed
+func runQuestionTimer(room *Room) {
+	time.Sleep(matchStartDelay)
+
+	for index := range room.Questions {
+		room.mu.Lock()
+		if room.Closed {
+			room.mu.Unlock()
+			return
+		}
+		room.startQuestion(index)
+		room.mu.Unlock()
+
+		// Wait until the deadline or until every player has answered the question
+		timer := time.NewTimer(questionDuration)
+		select {
+		case <-timer.C:
+		case <-room.answered:
+			timer.Stop()
+		}
+
+		room.mu.Lock()
+		if room.Closed {
+			room.mu.Unlock()
+			return
+		}
+		room.endQuestion(index)
+		room.mu.Unlock()
+	}
+
+	room.mu.Lock()
+	completed := !room.Closed && completeMatchIfFinished(room)
+	room.mu.Unlock()
+	if completed {
+		scheduleRoomExpiry(room)
+	}
+}
+
+// Broadcasts the question with its deadline to every player
+// The caller must hold the room lock
+func (room *Room) startQuestion(index int) {
+	room.CurrentQuestion = index
+	room.QuestionStartedAt = time.Now()
+	room.QuestionDeadline = room.QuestionStartedAt.Add(questionDuration)
+	question := room.Questions[index]
+	room.Rounds = append(room.Rounds, MatchRound{
+		QuestionId:    question.ID.Hex(),
+		Category:      question.Category,
+		Question:      question.Question,
+		Options:       question.Options,
+		CorrectOption: question.CorrectOption,
+		StartedAt:     room.QuestionStartedAt,
+	})
+	// Drop a signal left over from the previous question
+	select {
+	case <-room.answered:
+	default:
+	}
+
+	startMessage := QuestionStartMessage{
+		QuestionIndex: index,
+		Question:      question,
+		Deadline:      room.QuestionDeadline.UnixMilli(),
+		Duration:      questionDuration.Milliseconds(),
+	}
+	room.broadcast("question_start", startMessage)
+	room.scheduleBotAnswers(index)
+}
+
+// Records a timeout for every player who did not answer and broadcasts the correct option with the points and standings so far
+// The caller must hold the room lock
+func (room *Room) endQuestion(index int) {
+	question := room.Questions[index]
+	room.Rounds[index].EndedAt = time.Now()
+	for i := range room.Players {
+		player := &room.Players[i]
+		if len(player.Answers) > index {
+			continue
+		}
+		player.Answers = append(player.Answers, AnswerRecord{
+			QuestionId:     question.ID.Hex(),
+			SelectedOption: -1,
+			TimedOut:       true,
+			Points:         player.PlayerPoints,
+			TimeTaken:      questionDuration,
+			ReceivedAt:     room.QuestionDeadline,
+		})
+	}
+
+	endMessage := QuestionEndMessage{
+		QuestionIndex: index,
+		QuestionId:    question.ID.Hex(),
+		CorrectOption: question.CorrectOption,
+		Points:        make(map[string]uint16),
+	}
+	for _, player := range room.Players {
+		endMessage.Points[player.ProfileName] = player.PlayerPoints
+	}
+	standingsMessage := StandingsMessage{
+		QuestionIndex: index,
+		Standings:     room.standings(),
+	}
+	if room.Mode == modeTeams {
+		standingsMessage.TeamPoints = room.teamPoints()
+	}
+	room.broadcast("question_end", endMessage)
+	room.broadcast("standings", standingsMessage)
+}
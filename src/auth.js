@@ -0,0 +1,86 @@
+// The access token is refreshed this long before it expires so a request never goes out with an expired token
+const REFRESH_MARGIN_MS = 60 * 1000;
+
+// Refresh running right now, the refresh token is rotated on every refresh so a second refresh at the same time would be rejected
+let refreshing = null;
+
+// Access token of the logged in profile, the server wants it on every protected endpoint and when opening the websocket
+export const getToken = () => {
+  return localStorage.getItem("token");
+};
+
+// Saves the tokens of a login or refresh response (expiresAt is in unix seconds)
+const saveTokens = (session) => {
+  localStorage.setItem("token", session.token);
+  localStorage.setItem("tokenExpiresAt", session.expiresAt);
+  localStorage.setItem("refreshToken", session.refreshToken);
+};
+
+// Saves the profile and the tokens of a successful login
+export const saveSession = (profileName, session) => {
+  localStorage.setItem("profileName", profileName);
+  saveTokens(session);
+};
+
+// Trades the refresh token for a new access token (and a new refresh token) and returns the access token
+export const refreshSession = () => {
+  if (!refreshing) {
+    refreshing = fetch("http://localhost:5000/refresh", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        refreshToken: localStorage.getItem("refreshToken"),
+      }),
+    })
+      .then(async (response) => {
+        if (!response.ok) {
+          throw new Error(await response.text());
+        }
+        const session = await response.json();
+        saveTokens(session);
+        return session.token;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+};
+
+// Access token which is still valid for a while, refreshed first when it is about to expire
+export const getFreshToken = async () => {
+  const expiresAt = Number(localStorage.getItem("tokenExpiresAt"));
+  if (getToken() && expiresAt * 1000 - Date.now() > REFRESH_MARGIN_MS) {
+    return getToken();
+  }
+  try {
+    return await refreshSession();
+  } catch (err) {
+    // Let the request go out with the old token, the server answers 401 when the session is really over
+    console.error("Error refreshing the session", err);
+    return getToken();
+  }
+};
+
+// Same as fetch but sends the access token, protected endpoints answer 401 without it
+// A 401 means the token expired or was revoked in between so the session is refreshed and the request sent once more
+export const authFetch = async (url, options = {}) => {
+  const send = (token) =>
+    fetch(url, {
+      ...options,
+      headers: {
+        ...options.headers,
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  const response = await send(await getFreshToken());
+  if (response.status !== 401) {
+    return response;
+  }
+  try {
+    return await send(await refreshSession());
+  } catch (err) {
+    console.error("Error refreshing the session", err);
+    return response;
+  }
+};
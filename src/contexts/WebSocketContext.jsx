@@ -5,6 +5,10 @@ import React, {
   useRef,
   useState,
 } from "react";
+import { getFreshToken, getToken } from "../auth";
+
+// Version of the websocket protocol, every message in both directions is wrapped in an envelope carrying it
+const PROTOCOL_VERSION = 1;
 
 const WebSocketContext = createContext();
 
@@ -14,38 +18,75 @@ export const useWebSocket = () => {
 
 export const WebSocketProvider = ({ children }) => {
   const [ws, setWs] = useState(null);
+  // Seq of the last message sent, error replies of the server point back to it
+  const seq = useRef(0);
 
   useEffect(() => {
-    const websocket = new WebSocket("ws://localhost:5000/ws"); // Update to your WebSocket URL
-    websocket.onmessage = (e) => {
-      const data = JSON.parse(e.data);
-      //opponent_total_points
-      console.log("Message received in Room:", data);
-    };
-    websocket.onopen = () => {
-      console.log("WebSocket connected");
-      setWs(websocket);
-    };
+    let websocket = null;
+    // Set on unmount so a socket opened after the token refresh is closed right away
+    let unmounted = false;
 
-    websocket.onclose = () => {
-      console.log("WebSocket disconnected");
-      setWs(null);
-    };
+    const connect = async () => {
+      if (!getToken()) {
+        console.error("Not logged in, the WebSocket needs a token");
+        return;
+      }
+      // The server checks the access token on every upgrade so refresh it first when it is about to expire
+      const token = await getFreshToken();
+      if (unmounted) {
+        return;
+      }
+      websocket = new WebSocket(
+        `ws://localhost:5000/ws?token=${encodeURIComponent(token)}`
+      ); // Update to your WebSocket URL
+      websocket.onmessage = (e) => {
+        const { type, payload } = JSON.parse(e.data);
+        console.log("Message received in Room:", type, payload);
+      };
+      websocket.onopen = () => {
+        console.log("WebSocket connected");
+        setWs(websocket);
+      };
 
-    websocket.onerror = (error) => {
-      console.error("WebSocket error:", error);
+      websocket.onclose = () => {
+        console.log("WebSocket disconnected");
+        setWs(null);
+      };
+
+      websocket.onerror = (error) => {
+        console.error("WebSocket error:", error);
+      };
     };
+    connect();
 
     // Clean up on unmount
     return () => {
+      unmounted = true;
       if (websocket) {
         websocket.close();
       }
     };
   }, []);
 
+  // Wraps the payload in an envelope of the message type and sends it, the profile name always comes from the token
+  const send = (type, payload) => {
+    if (!ws) {
+      console.error(`WebSocket is not connected, ${type} was not sent`);
+      return;
+    }
+    seq.current += 1;
+    ws.send(
+      JSON.stringify({
+        type,
+        version: PROTOCOL_VERSION,
+        seq: seq.current,
+        payload,
+      })
+    );
+  };
+
   return (
-    <WebSocketContext.Provider value={{ ws }}>
+    <WebSocketContext.Provider value={{ ws, send }}>
       {children}
     </WebSocketContext.Provider>
   );
@@ -1,5 +1,6 @@
 import React, { useEffect, useState } from "react";
 import Header from "../components/Header";
+import { authFetch } from "../auth";
 const achievements = [
   //* Completed from backend this is done when updating trophies and then incrementing the winning match count
   {
@@ -35,7 +36,7 @@ export default function Achievements() {
   const [achievementsData, setAchievementsData] = useState([]);
   useEffect(() => {
     const getAchievementData = async () => {
-      const response = await fetch(
+      const response = await authFetch(
         `http://localhost:5000/get-achievement-data?profileName=${profileName}`
       );
       const data = await response.json();
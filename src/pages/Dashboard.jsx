@@ -5,7 +5,7 @@ import { useNavigate } from "react-router-dom";
 import { useWebSocket } from "../contexts/WebSocketContext";
 import Header from "../components/Header";
 export default function Dashboard() {
-  const { ws } = useWebSocket();
+  const { ws, send } = useWebSocket();
   const [totalTrophies, setTotalTrophies] = useState(null);
   const [isInQueue, setIsInQueue] = useState(false);
   const [inQueueCountUp, setInQueueCountUp] = useState(0);
@@ -40,17 +40,24 @@ export default function Dashboard() {
     // Log ws whenever it changes
     if (ws) {
       ws.onmessage = (e) => {
-        const data = JSON.parse(e.data);
-        console.log(data);
-        if (data.message === "Match found!") {
-          setOpponentName(data.opponent);
+        // Every message is an envelope, the type tells what the payload holds
+        const { type, payload } = JSON.parse(e.data);
+        console.log(type, payload);
+        if (type === "error") {
+          console.error(`${payload.code}: ${payload.message}`);
+        }
+        if (type === "match_found") {
+          setOpponentName(payload.opponent);
           setIsMatchFound(true);
           const intervalId = setInterval(() => {
             setJoiningRoomCountDown((prev) => {
               if (prev == 0) {
                 // Clear interval first
                 clearInterval(intervalId);
-                history(`/room?id=${data.roomId}&profileName=${profileName}`);
+                // The room opens a new socket which takes the seat back with the resume token
+                history(
+                  `/room?id=${payload.roomId}&profileName=${profileName}&opponent=${payload.opponent}&resumeToken=${payload.resumeToken}`
+                );
                 return 0;
               }
               return prev - 1;
@@ -65,13 +72,8 @@ export default function Dashboard() {
     // First send the player name details to the websocket server after clicking "Find Match" button
     if (ws) {
       console.log(profileName);
-      ws.send(
-        JSON.stringify({
-          action: "connect",
-          profileName: profileName,
-          totalTrophies: 20,
-        })
-      );
+      // The server knows the profile from the token, no mode means a duel
+      send("connect", {});
     } else {
       console.error("WebSocket is not initialized during button click.");
     }
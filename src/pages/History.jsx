@@ -1,5 +1,6 @@
 import { useEffect, useState } from "react";
 import Header from "../components/Header";
+import { authFetch } from "../auth";
 
 const History = () => {
   // ! LOCAL STORAGE AS OF NOW
@@ -9,7 +10,7 @@ const History = () => {
   const [historyData, setHistoryData] = useState([]);
   useEffect(() => {
     const getHistoryData = async () => {
-      const response = await fetch(
+      const response = await authFetch(
         `http://localhost:5000/get-history-data?profileName=${profileName}`
       );
       const data = await response.json();
@@ -2,6 +2,7 @@ import "../index.css";
 import "../App.css";
 import { useState } from "react";
 import { Link, useNavigate } from "react-router-dom";
+import { saveSession } from "../auth";
 export default function Login() {
   const [profileName, setProfileName] = useState("");
   const [profilePassword, setProfilePassword] = useState("");
@@ -33,10 +34,9 @@ export default function Login() {
           }),
         }
       );
-      const data = await response.text();
-
       // Error message from backend
       if (!response.ok) {
+        const data = await response.text();
         console.log(data);
         setErrorMessage(data);
         setTimeout(() => {
@@ -45,8 +45,10 @@ export default function Login() {
       }
       // Successful Login because both the profile name and profile password is valid
       else {
-        console.log(data);
-        setErrorMessage(data);
+        // The response holds the access token (sent with every request and the websocket) and the refresh token
+        const data = await response.json();
+        saveSession(profileName, data);
+        setErrorMessage(data.message);
         setTimeout(() => {
           setErrorMessage("");
         }, 4000);
@@ -3,6 +3,7 @@ import "../App.css";
 import { useEffect, useState } from "react";
 import { Link } from "react-router-dom";
 import Header from "../components/Header";
+import { authFetch } from "../auth";
 
 export default function Profile() {
   // ! TEMP SOLUTION USING PLAYER NAME FROM LOCAL STORAGE
@@ -58,10 +59,13 @@ export default function Profile() {
     const formData = new FormData();
     formData.append("profileImage", e.target.files[0]);
     formData.append("profileName", profileData.profileName);
-    const response = await fetch("http://localhost:5000/update-profile-image", {
-      method: "POST",
-      body: formData,
-    });
+    const response = await authFetch(
+      "http://localhost:5000/update-profile-image",
+      {
+        method: "POST",
+        body: formData,
+      }
+    );
     const data = await response.json();
     console.log(data);
   };
@@ -81,8 +85,11 @@ export default function Profile() {
     if (JSON.stringify(profileData) === JSON.stringify(initialProfileData)) {
       return;
     }
-    await fetch(
-      `http://localhost:5000/update-profile-data?profileName=Nirmala Kumari`,
+    // The profile name must be the one of the token
+    await authFetch(
+      `http://localhost:5000/update-profile-data?profileName=${encodeURIComponent(
+        profileName
+      )}`,
       {
         method: "PATCH",
         headers: { "Content-Type": "application/json" },
@@ -4,218 +4,144 @@ import Header from "../components/Header";
 
 const Room = () => {
   const url = new URLSearchParams(window.location.search);
-  const { ws } = useWebSocket();
-  const [questions, setQuestions] = useState([]);
+  const { ws, send } = useWebSocket();
+  // The server sends the questions one by one (question_start) and decides when they end
+  const [question, setQuestion] = useState(null);
   const [currentQuestionIndex, setCurrentQuestionIndex] = useState(0);
+  const [questionDeadline, setQuestionDeadline] = useState(null);
+  const [hasAnswered, setHasAnswered] = useState(false);
   // Initializing the array with 0
   const [totalPoints, setTotalPoints] = useState([0]);
-  const [questionTimer, setQuestionTimer] = useState(5);
+  const [questionTimer, setQuestionTimer] = useState(null);
   const [isMatchCompleted, setIsMatchCompleted] = useState(false);
-  const [isLightingReflexesCompleted, setIsLightningReflexesCompleted] =
-    useState(false);
   const [roomId] = useState(url.get("id"));
   const [profileName] = useState(url.get("profileName"));
   const [opponentName] = useState(url.get("opponent"));
+  const [resumeToken] = useState(url.get("resumeToken"));
   const [opponentTotalPoints, setOpponentTotalPoints] = useState(null);
   const [matchResult, setMatchResult] = useState(null);
   useEffect(() => {
     if (ws) {
       // Handle incoming messages or perform actions
       ws.onmessage = (e) => {
-        console.log(e.data);
-        const data = JSON.parse(e.data);
-        console.log(data);
-        setOpponentTotalPoints(data);
+        // Every message is an envelope, the type tells what the payload holds
+        const { type, payload } = JSON.parse(e.data);
+        console.log(type, payload);
+        switch (type) {
+          // The dashboard socket was closed so this socket takes the seat back
+          case "resumed":
+            if (payload.question) {
+              setQuestion(payload.question);
+              setCurrentQuestionIndex(payload.questionIndex);
+              setQuestionDeadline(payload.deadline);
+            }
+            setHasAnswered(payload.answered);
+            if (payload.points[profileName]) {
+              setTotalPoints(payload.points[profileName]);
+            }
+            break;
+          case "question_start":
+            setQuestion(payload.question);
+            setCurrentQuestionIndex(payload.questionIndex);
+            setQuestionDeadline(payload.deadline);
+            setHasAnswered(false);
+            break;
+          // Points of every player once the question is over
+          case "question_end":
+            setTotalPoints((prev) => [...prev, payload.points[profileName]]);
+            if (opponentName) {
+              setOpponentTotalPoints(payload.points[opponentName]);
+            }
+            break;
+          case "match_result": {
+            const points = payload.points[profileName] || [0];
+            setTotalPoints(points);
+            if (opponentName && payload.points[opponentName]) {
+              const opponentPoints = payload.points[opponentName];
+              setOpponentTotalPoints(opponentPoints[opponentPoints.length - 1]);
+            }
+            if (payload.isDrawn) {
+              setMatchResult("tie");
+            } else if (payload.winner === profileName) {
+              setMatchResult("won");
+            } else {
+              setMatchResult("lost");
+            }
+            // Marking match as completed
+            setIsMatchCompleted(true);
+            break;
+          }
+          case "error":
+            console.error(`${payload.code}: ${payload.message}`);
+            break;
+        }
       };
+      send("resume", { roomId, resumeToken });
     }
     return () => {
       // Optional cleanup if needed
     };
   }, [ws]);
 
+  // Count down to the deadline of the current question, the server moves to the next question
   useEffect(() => {
-    // const fetchQuestions = async () => {
-    //   const response = await fetch(
-    //     "https://opentdb.com/api.php?amount=10&category=21&type=multiple"
-    //   );
-    //   const data = await response.json();
-    //   setQuestions
-    // };
-    // fetchQuestions();
-    setQuestions([
-      {
-        type: "multiple",
-        difficulty: "easy",
-        category: "Sports",
-        question: "In baseball, how many fouls are an out?",
-        correct_answer: "0",
-        incorrect_answers: ["5", "3", "2"],
-      },
-      {
-        type: "multiple",
-        difficulty: "medium",
-        category: "Sports",
-        question:
-          "Which NBA player won Most Valuable Player for the 1999-2000 season?",
-        correct_answer: "Shaquille O&#039;Neal",
-        incorrect_answers: ["Allen Iverson", "Kobe Bryant", "Paul Pierce"],
-      },
-      {
-        type: "multiple",
-        difficulty: "easy",
-        category: "Sports",
-        question: "What team won the 2016 MLS Cup?",
-        correct_answer: "Seattle Sounders",
-        incorrect_answers: ["Colorado Rapids", "Toronto FC", "Montreal Impact"],
-      },
-      {
-        type: "multiple",
-        difficulty: "medium",
-        category: "Sports",
-        question:
-          "What is the exact length of one non-curved part in Lane 1 of an Olympic Track?",
-        correct_answer: "84.39m",
-        incorrect_answers: ["100m", "100yd", "109.36yd"],
-      },
-      {
-        type: "multiple",
-        difficulty: "medium",
-        category: "Sports",
-        question:
-          "Which of the following player scored a hat-trick during their Manchester United debut?",
-        correct_answer: "Wayne Rooney",
-        incorrect_answers: [
-          "Cristiano Ronaldo",
-          "Robin Van Persie",
-          "David Beckham",
-        ],
-      },
-    ]);
-  }, []);
-
-  // Run side-effects on question timer on first render and when the question changes
-  useEffect(() => {
-    const intervalId = setInterval(() => {
-      // Question timer starts from 5 if the timer count is not 0 then decrement timer by 1 else if the timer is 0 then reset question timer to 5
-      setQuestionTimer((prevTimer) => {
-        if (prevTimer === 0) {
-          setQuestionTimer(5);
-          //!  BREAK 5M
-          // Increment the question index
-          setCurrentQuestionIndex((prevIndex) => {
-            const nextIndex = prevIndex + 1;
-            if (nextIndex < questions.length) {
-              return nextIndex;
-            }
-            // Clear the previously created interval
-            clearInterval(intervalId);
-            return prevIndex;
-          });
-        }
-        return prevTimer - 1;
-      });
-    }, 1000);
+    if (!questionDeadline) {
+      return;
+    }
+    const updateTimer = () => {
+      setQuestionTimer(
+        Math.max(0, Math.ceil((questionDeadline - Date.now()) / 1000))
+      );
+    };
+    updateTimer();
+    const intervalId = setInterval(updateTimer, 1000);
 
     return () => clearInterval(intervalId);
-  }, [questions.length]);
+  }, [questionDeadline]);
 
   const handleOptionClick = (selectedOption) => {
-    // Current question
-    const currentQuestion = questions[currentQuestionIndex];
-
-    const isCorrectOption =
-      currentQuestion.correct_answer === selectedOption ? true : false;
-    // Gets the last recorded point
-    const previousPoint = totalPoints[totalPoints.length - 1];
-    // Tracking points locally
-    // Add the previously recoreded points with 20 to get new current point
-    const newTotalPoint = isCorrectOption ? previousPoint + 20 : previousPoint;
-    // Move to the next question
-    if (currentQuestionIndex < questions.length - 1) {
-      // Before moving the index & resetting the time counter check the time taken to select the correct option
-      // Anwer is correct and answer with 2 seconds remaining = within 3 seconds
-      if (isCorrectOption && questionTimer < 2) {
-        setIsLightningReflexesCompleted(true);
-      }
-      setCurrentQuestionIndex(currentQuestionIndex + 1);
-    } else {
-      // Send these information after completing all the questions
-
-      // * We need to send the profile name and player's points data once the player completes the quiz to the websocket server since the opponent needs that data once both player gets the confirmation (gets the opponent's score it is marked as match completed this is where the achivements data process happens)
-      // Sending the array of points to the socket server
-      ws.send(
-        JSON.stringify({
-          action: "player_completed",
-          roomId: roomId,
-          profileName: profileName,
-          playerPoints: [...totalPoints, newTotalPoint],
-        })
-      );
-      // Marking match as completed
-      setIsMatchCompleted(true);
+    // One answer per question, the server checks it and scores it
+    if (hasAnswered) {
+      return;
     }
-    setTotalPoints((prev) => [...prev, newTotalPoint]);
+    send("submit_answer", {
+      roomId: roomId,
+      questionId: question.id,
+      selectedOption: selectedOption,
+      clientTimestamp: Date.now(),
+    });
+    setHasAnswered(true);
   };
 
-  // Run sideeffect whenever the match is completed and when we get the opponent's points
+  // Close the websocket once the match is over
   useEffect(() => {
-    // You have to complete the match and also need the opponent's total points then it is considered as the end of the match (we have completed the quiz & have the opponent's total points)
-    const totalPoint = totalPoints[totalPoints.length - 1];
-    const opponentTotalPoint =
-      opponentTotalPoints[opponentTotalPoints.length - 1];
-    if (isMatchCompleted && opponentTotalPoints) {
-      if (totalPoint > opponentTotalPoint) {
-        setMatchResult("won");
-      } else if (totalPoint < opponentTotalPoint) {
-        setMatchResult("lost");
-      } else {
-        setMatchResult("tie");
-      }
-
-      // * If the player disconnects mid game cancel the achievements he made in the game
-
-      ws.send(
-        JSON.stringify({
-          action: "match_completed",
-          roomId: roomId,
-          profileName: profileName,
-          playerPoints: [totalPoints[totalPoints.length - 1]],
-          opponentName: "Mania",
-          opponentTotalPoints: opponentTotalPoints,
-          isPerfectScore: totalPoint === 100 ? true : false,
-          isLightingReflexesCompleted: isLightingReflexesCompleted,
-        })
-      );
-      // Close the websocket server
+    if (isMatchCompleted && ws) {
       ws.close();
     }
-  }, [isMatchCompleted, opponentTotalPoints]);
+  }, [isMatchCompleted]);
   return (
     <div className="flex flex-col h-screen w-screen text-white font-roboto">
       <Header />
       <div className="flex flex-col h-full w-full bg-[#C5E6DF] text-black items-center justify-center">
         {/* Render the current question */}
         {!isMatchCompleted ? (
-          questions.length > 0 && (
+          question && (
             <div className="flex flex-col items-start text-sm space-y-4">
               {/* Clock timer for question */}
               {questionTimer}
               {/* Question */}
               {/* Highest Width so this is the decider to items center / justify center */}
               <p className="w-60">
-                {currentQuestionIndex + 1}.{" "}
-                {questions[currentQuestionIndex].question}
+                {currentQuestionIndex + 1}. {question.question}
               </p>
               {/* Options */}
               <div className="flex flex-col space-y-4">
-                {/* Include both correct and incorrect answers and shuffle them */}
-                {[
-                  ...questions[currentQuestionIndex].incorrect_answers,
-                  questions[currentQuestionIndex].correct_answer,
-                ].map((option, index) => (
+                {/* The server sends the options already shuffled and answers are the index of the option */}
+                {question.options.map((option, index) => (
                   <button
                     key={index}
-                    onClick={() => handleOptionClick(option)}
+                    onClick={() => handleOptionClick(index)}
+                    disabled={hasAnswered}
                     className="p-4 text-left bg-gray-800 text-white rounded-3xl hover:bg-green-400 duration-300"
                   >
                     <span>{index + 1}.</span> {option}
@@ -226,7 +152,7 @@ const Room = () => {
           )
         ) : (
           <h1 className="text-2xl">
-            <p>Your Points: {totalPoints}</p>
+            <p>Your Points: {totalPoints[totalPoints.length - 1]}</p>
             <p>
               Opponent Points:{" "}
               {opponentTotalPoints !== null